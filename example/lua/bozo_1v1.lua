@@ -1,49 +1,131 @@
 local players = {}
+-- players in a match that hasn't started yet, by match id: if the ready
+-- check fails they go back in the pool, keeping their place
+local pending = {}
+-- unlike the tables above, this survives restarts
+local matchesMade, err = queue.Store.Get("matchesMade")
+if err then
+	queue.Log("could not load the match count, counting from 0: " .. err, "warn")
+end
+matchesMade = tonumber(matchesMade) or 0
 
 -- TODO: richer player data structure. perhaps store 'players' outside the lua?
 function queue.PlayerJoined(playerName)
+	local info = queue.GetPlayerInfo(playerName)
 	players[playerName] = {
 		name = playerName,
-		skillLevel = 9001
+		-- players nobody has rated yet start in the middle
+		skillLevel = info and info.rating or 1500,
+		joinedAt = os.time()
 	}
 
 end
 
 function queue.PlayerLeft(playerName)
 	players[playerName] = nil
-	print("a player left ", playerName, " ", queue.GetTitle())
 end
 
+-- sets up a match for one pair; if it can't, the pair waits for next time
+-- and the other pairs carry on
+local function matchPair(pair)
+	local map, mapReason = queue.PickMap({ pair[1].name, pair[2].name })
+	if not map then
+		queue.Log("could not pick a map: " .. mapReason, "warn")
+		return
+	end
+
+	local game, gameReason = queue.PickGame()
+	if not game then
+		queue.Log("could not pick a game: " .. gameReason, "warn")
+		return
+	end
+
+	local id = queue.NewMatch({
+		map = map,
+		mapReason = mapReason,
+		game = game,
+		gameReason = gameReason,
+		players = {
+			{
+				name = pair[1].name,
+				ally = 0,
+				team = 0,
+			},
+			{
+				name = pair[2].name,
+				ally = 1,
+				team = 1,
+			}
+		}
+	})
+
+	if id then
+		matchesMade = matchesMade + 1
+		local ok, err = queue.Store.Set("matchesMade", matchesMade)
+		if not ok then
+			queue.Log("could not save the match count: " .. err, "warn")
+		end
+
+		pending[id] = {
+			players[pair[1].name],
+			players[pair[2].name]
+		}
+		players[pair[1].name] = nil
+		players[pair[2].name] = nil
+	end
+end
+
+local function tryMatch()
+	local candidates = {}
+	for _, name in ipairs(queue.GetPlayerList()) do
+		local player = players[name]
+		if player then
+			table.insert(candidates, {
+				name = name,
+				rating = player.skillLevel,
+				waited = os.time() - player.joinedAt
+			})
+		end
+	end
+
+	-- start strict, and accept a 100 point wider gap for every 10 seconds waited
+	local matched = queue.util.PairByRating(candidates, { base = 100, growth = 10, max = 1000 })
+	for _, pair in ipairs(matched) do
+		matchPair(pair)
+	end
+end
+
+-- right after someone joins or leaves: a match may be possible straight away
+queue.TryMatch = tryMatch
+
+-- waits grow, and with them the rating gaps players accept: look again
+-- every so often even when nobody comes or goes
 function queue.Update(n)
-	local title = queue.GetTitle()
 	if n % 5 == 0 then
-		local playerList = queue.GetPlayerList()
-		if #playerList == 2 then
-			print(queue.GetTitle(), " omg two players to match ", n)
-			local maps = queue.GetMapList()
-			local games = queue.GetGameList()
-
-			queue.NewMatch({
-				map = maps[1],
-				game = games[1],
-				engineVersion = "99",
-				players = {
-					{
-						name = playerList[1],
-						ally = 0,
-						team = 0,
-					},
-					{
-						name = playerList[2],
-						ally = 1,
-						team = 1,
-					}
-				}
-			})
+		tryMatch()
+	end
+end
+
+function queue.ReadyCheckFailed(match, reason)
+	queue.Log("match " .. match.id .. " fell through: " .. reason)
+	local unready = {}
+	for _, name in ipairs(match.unready) do
+		unready[name] = true
+	end
 
-			players[playerList[1]] = nil
-			players[playerList[2]] = nil
+	-- whoever did ready up goes back in the pool, as if they never left it
+	for _, player in ipairs(pending[match.id] or {}) do
+		if not unready[player.name] then
+			players[player.name] = player
 		end
 	end
+	pending[match.id] = nil
 end
 
+function queue.MatchStarted(match)
+	pending[match.id] = nil
+end
+
+function queue.MatchEnded(match, result)
+	queue.Log("match " .. match.id .. " on " .. match.map .. " ended after " .. result.duration .. " seconds; " .. matchesMade .. " matches made so far", "debug")
+end
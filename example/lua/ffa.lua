@@ -0,0 +1,103 @@
+-- free for all: players of similar rating are bucketed into full matches.
+-- once the longest waiting player has waited a minute, whoever is waiting
+-- plays, as long as there are at least minPlayers of them.
+local players = {}
+-- players in a match that hasn't started yet, by match id
+local pending = {}
+
+-- seconds before a smaller match beats waiting for a full one
+local patience = 60
+
+function queue.PlayerJoined(playerName)
+	local info = queue.GetPlayerInfo(playerName)
+	players[playerName] = {
+		name = playerName,
+		rating = info and info.rating or 1500,
+		joinedAt = os.time()
+	}
+end
+
+function queue.PlayerLeft(playerName)
+	players[playerName] = nil
+end
+
+local function startMatch(chosen)
+	local names = {}
+	local seats = {}
+	for i, player in ipairs(chosen) do
+		table.insert(names, player.name)
+		-- everyone for themselves
+		table.insert(seats, { name = player.name, ally = i - 1, team = i - 1 })
+	end
+
+	local map, mapReason = queue.PickMap(names)
+	local game, gameReason = queue.PickGame()
+	if not map or not game then
+		return
+	end
+
+	local id = queue.NewMatch({
+		map = map,
+		mapReason = mapReason,
+		game = game,
+		gameReason = gameReason,
+		players = seats
+	})
+
+	if id then
+		pending[id] = chosen
+		for _, player in ipairs(chosen) do
+			players[player.name] = nil
+		end
+	end
+end
+
+local function tryMatch()
+	local minPlayers, maxPlayers = queue.GetPlayerLimits()
+
+	local candidates = {}
+	local longest = 0
+	for _, name in ipairs(queue.GetPlayerList()) do
+		local player = players[name]
+		if player then
+			player.waited = os.time() - player.joinedAt
+			longest = math.max(longest, player.waited)
+			table.insert(candidates, player)
+		end
+	end
+
+	local buckets, rest = queue.util.BucketFFA(candidates, maxPlayers)
+	for _, bucket in ipairs(buckets) do
+		startMatch(bucket)
+	end
+
+	if longest >= patience and #rest >= minPlayers then
+		startMatch(rest)
+	end
+end
+
+queue.TryMatch = tryMatch
+
+function queue.Update(n)
+	if n % 5 == 0 then
+		tryMatch()
+	end
+end
+
+function queue.ReadyCheckFailed(match, reason)
+	local unready = {}
+	for _, name in ipairs(match.unready) do
+		unready[name] = true
+	end
+
+	for _, player in ipairs(pending[match.id] or {}) do
+		if not unready[player.name] then
+			players[player.name] = player
+		end
+	end
+	pending[match.id] = nil
+end
+
+function queue.MatchStarted(match)
+	pending[match.id] = nil
+end
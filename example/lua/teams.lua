@@ -0,0 +1,98 @@
+-- team games: once a full match's worth of players is waiting, the longest
+-- waiting are split into two teams of equal size and rating. the queue's
+-- maxPlayers is the match size, so it must be even.
+local players = {}
+-- players in a match that hasn't started yet, by match id
+local pending = {}
+
+function queue.PlayerJoined(playerName)
+	local info = queue.GetPlayerInfo(playerName)
+	players[playerName] = {
+		name = playerName,
+		rating = info and info.rating or 1500,
+		joinedAt = os.time()
+	}
+end
+
+function queue.PlayerLeft(playerName)
+	players[playerName] = nil
+end
+
+local function tryMatch()
+	local _, size = queue.GetPlayerLimits()
+
+	local candidates = {}
+	for _, name in ipairs(queue.GetPlayerList()) do
+		local player = players[name]
+		if player then
+			player.waited = os.time() - player.joinedAt
+			table.insert(candidates, player)
+		end
+	end
+
+	table.sort(candidates, function(a, b) return a.waited > b.waited end)
+	while #candidates >= size do
+		local chosen = {}
+		local names = {}
+		for i = 1, size do
+			local player = table.remove(candidates, 1)
+			table.insert(chosen, player)
+			table.insert(names, player.name)
+		end
+
+		local teams = queue.util.BalanceExhaustive(chosen, 2)
+		local map, mapReason = queue.PickMap(names)
+		local game, gameReason = queue.PickGame()
+		if not teams or not map or not game then
+			return
+		end
+
+		local seats = {}
+		for team, members in ipairs(teams) do
+			for _, player in ipairs(members) do
+				table.insert(seats, { name = player.name, ally = team - 1, team = #seats })
+			end
+		end
+
+		local id = queue.NewMatch({
+			map = map,
+			mapReason = mapReason,
+			game = game,
+			gameReason = gameReason,
+			players = seats
+		})
+
+		if id then
+			pending[id] = chosen
+			for _, player in ipairs(chosen) do
+				players[player.name] = nil
+			end
+		end
+	end
+end
+
+queue.TryMatch = tryMatch
+
+function queue.Update(n)
+	if n % 5 == 0 then
+		tryMatch()
+	end
+end
+
+function queue.ReadyCheckFailed(match, reason)
+	local unready = {}
+	for _, name in ipairs(match.unready) do
+		unready[name] = true
+	end
+
+	for _, player in ipairs(pending[match.id] or {}) do
+		if not unready[player.name] then
+			players[player.name] = player
+		end
+	end
+	pending[match.id] = nil
+end
+
+function queue.MatchStarted(match)
+	pending[match.id] = nil
+end
@@ -1,26 +1,189 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/kanatohodets/go-match/matchbot"
+	"github.com/kanatohodets/go-match/matchbot/store"
+	"github.com/kanatohodets/go-match/spring/game"
+	"github.com/kanatohodets/go-match/spring/lobby/client"
 	"os"
 	"os/signal"
+	"strings"
 )
 
+// where `run` serves the admin API, and `status` looks for it
+const defaultAdmin = "localhost:8201"
+
+type command struct {
+	summary string
+	// gets the arguments after the command name, returns the exit status
+	run func(args []string) int
+}
+
+var commands = map[string]command{
+	"run":           {"connect to the lobby server and host queues", run},
+	"validate":      {"lint queues files and Lua queue scripts", validate},
+	"simulate":      {"run a queue script against synthetic players", simulate},
+	"render-script": {"print the startscript for a sample match", renderScript},
+	"status":        {"ask a running matchbot what it is up to", status},
+}
+
+var commandOrder = []string{"run", "validate", "simulate", "render-script", "status"}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: go-match <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %-14s %v\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "`go-match <command> -h` lists a command's flags.")
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+// engineFlags collects repeated -engine version=path flags.
+type engineFlags game.Engines
+
+func (e engineFlags) String() string {
+	pairs := []string{}
+	for version, path := range e {
+		pairs = append(pairs, version+"="+path)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (e engineFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("want version=path, got %q", value)
+	}
+	e[parts[0]] = parts[1]
+	return nil
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	server := flags.String("server", "localhost:8200", "lobby server address")
+	user := flags.String("user", "", "lobby account to log in as; required")
+	password := flags.String("password", os.Getenv("GOMATCH_PASSWORD"), "lobby account password; defaults to $GOMATCH_PASSWORD")
+	queuesFile := flags.String("queues", "example/queue.json", "queues file, watched for changes")
+	admin := flags.String("admin", defaultAdmin, "address to serve the admin API on; empty to disable")
+	stateFile := flags.String("state", "state.json", "where queue scripts keep state across restarts")
+	verbose := flags.Bool("v", false, "debug logging")
+	tlsMode := flags.String("tls", "off", "lobby connection encryption: off, starttls, direct or auto (starttls, falling back to direct)")
+	caFile := flags.String("ca", "", "PEM bundle of CAs to trust instead of the system roots")
+	serverName := flags.String("server-name", "", "name to check the server's certificate against; defaults to the -server host")
+	certFile := flags.String("cert", "", "PEM client certificate to present to the server")
+	keyFile := flags.String("key", "", "PEM key for -cert")
+	engines := engineFlags{}
+	flags.Var(engines, "engine", "spring-dedicated binary for an engine version, as version=path; repeatable, at least one required")
+	flags.Parse(args)
+
 	log.SetLevel(log.InfoLevel)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if *user == "" {
+		fmt.Fprintln(os.Stderr, "-user is required")
+		return 2
+	}
+
+	if len(engines) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one -engine version=path is required")
+		return 2
+	}
+
+	mode, err := client.ParseTLSMode(*tlsMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if mode == client.TLSOff && (*caFile != "" || *serverName != "" || *certFile != "" || *keyFile != "") {
+		fmt.Fprintln(os.Stderr, "-ca, -server-name, -cert and -key need -tls")
+		return 2
+	}
+
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Fprintln(os.Stderr, "-cert and -key go together")
+		return 2
+	}
+
+	state, err := store.NewFile(*stateFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": "main.run",
+			"file":  *stateFile,
+			"error": err,
+		}).Error("could not open the state file")
+		return 1
+	}
+
+	matchbot := matchbot.New(matchbot.Config{
+		Engines: game.Engines(engines),
+		Client: client.Config{
+			EventBuffer: client.DefaultEventBuffer,
+			Overflow:    client.OverflowDisconnect,
+			TLS: client.TLSConfig{
+				Mode:       mode,
+				CAFile:     *caFile,
+				ServerName: *serverName,
+				CertFile:   *certFile,
+				KeyFile:    *keyFile,
+			},
+		},
+		Store: state,
+	})
+
+	if *admin != "" {
+		go func() {
+			err := matchbot.ServeAdmin(*admin)
+			log.WithFields(log.Fields{
+				"event": "main.run",
+				"addr":  *admin,
+				"error": err,
+			}).Error("admin API stopped")
+		}()
+	}
 
-	matchbot := matchbot.New()
-	go matchbot.Start("localhost:8200", "FooUser", "foobar", "blorg.json")
+	failed := make(chan error, 1)
+	go func() {
+		failed <- matchbot.Start(*server, *user, *password, *queuesFile)
+	}()
 
 	// gracefully exit on SIGINT
 	// (mostly, make sure the server is told to clean up queues that this bot hosted)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
-	<-c
+	select {
+	case <-c:
+	case err := <-failed:
+		log.WithFields(log.Fields{
+			"event": "main.run",
+			"error": err,
+		}).Error("matchbot cannot log in to the server; check its account")
+		return 1
+	}
 
 	fmt.Println("exiting gracefully...")
 	matchbot.Shutdown()
-	os.Exit(0)
+	return 0
 }
@@ -0,0 +1,210 @@
+package matchbot
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/spring/lobby/client"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Status is a snapshot of what the matchbot is up to, for operators.
+type Status struct {
+	Connected  bool             `json:"connected"`
+	LoginState string           `json:"loginState"`
+	Connection ConnectionStatus `json:"connection"`
+	Queues     []QueueStatus    `json:"queues"`
+	// matches waiting for their players to ready up
+	ReadyChecks int               `json:"readyChecks"`
+	Events      client.EventStats `json:"events"`
+}
+
+// QueueStatus is one hosted queue.
+type QueueStatus struct {
+	Name    string `json:"name"`
+	Players int    `json:"players"`
+}
+
+// Status reports on the matchbot's connection and queues.
+func (m *Matchbot) Status() Status {
+	status := Status{
+		Connected:  m.client.Active(),
+		LoginState: m.client.LoginState().String(),
+		Queues:     []QueueStatus{},
+		Events:     m.client.Stats(),
+	}
+
+	m.connectionMut.Lock()
+	status.Connection = m.connection
+	m.connectionMut.Unlock()
+
+	m.do(func() {
+		players := map[string]int{}
+		for _, q := range m.players {
+			players[q.Name()]++
+		}
+
+		for name := range m.queues {
+			status.Queues = append(status.Queues, QueueStatus{
+				Name:    name,
+				Players: players[name],
+			})
+		}
+		status.ReadyChecks = len(m.ready)
+	})
+
+	sort.Slice(status.Queues, func(i, j int) bool {
+		return status.Queues[i].Name < status.Queues[j].Name
+	})
+	return status
+}
+
+// ServeAdmin serves the admin API on addr until it fails:
+//
+//	GET    /status         the matchbot's Status, as JSON
+//	POST   /queues         open a queue; the body is one queue definition, as in the queues file
+//	PUT    /queues/{name}  replace an open queue's definition
+//	DELETE /queues/{name}  close a queue; ?reason= is passed on to its players
+//
+// the queues file stays in charge: the next change to it undoes whatever was
+// opened, updated or closed through here.
+func (m *Matchbot) ServeAdmin(addr string) error {
+	return http.ListenAndServe(addr, m.adminHandler())
+}
+
+func (m *Matchbot) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(m.Status())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.ServeAdmin",
+				"error": err,
+			}).Warn("could not write status")
+		}
+	})
+
+	mux.HandleFunc("/queues", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		def, err := readDefinition(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if m.hosting(def.Name) {
+			http.Error(w, fmt.Sprintf("queue %v is already open", def.Name), http.StatusConflict)
+			return
+		}
+
+		m.adminDone(w, r, def.Name, m.OpenQueue(def))
+	})
+
+	mux.HandleFunc("/queues/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/queues/")
+		if name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			http.Error(w, "only PUT and DELETE are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !m.hosting(name) {
+			http.Error(w, fmt.Sprintf("queue %v is not open", name), http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			reason := r.URL.Query().Get("reason")
+			if reason == "" {
+				reason = "closed by an admin"
+			}
+			m.adminDone(w, r, name, m.CloseQueue(name, reason))
+			return
+		}
+
+		def, err := readDefinition(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if def.Name != name {
+			http.Error(w, fmt.Sprintf("definition is for %v, not %v: queues can't be renamed", def.Name, name), http.StatusBadRequest)
+			return
+		}
+
+		m.adminDone(w, r, name, m.UpdateQueue(def))
+	})
+
+	return mux
+}
+
+// biggest queue definition the admin API accepts
+const maxDefinitionSize = 1 << 20
+
+// readDefinition checks a request's body the way the queues file is checked.
+func readDefinition(w http.ResponseWriter, r *http.Request) (*queue.Definition, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDefinitionSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the queue definition: %v", err)
+	}
+
+	list := append(append([]byte("["), body...), ']')
+	defs, err := queue.ParseDefinitions(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue definition: %v", err)
+	}
+
+	if len(defs) != 1 {
+		return nil, fmt.Errorf("want exactly one queue definition, got %d", len(defs))
+	}
+	return defs[0], nil
+}
+
+// hosting is true if the queue is open.
+func (m *Matchbot) hosting(name string) bool {
+	open := false
+	m.do(func() {
+		_, open = m.queues[name]
+	})
+	return open
+}
+
+// adminDone answers an admin request which changed a queue.
+func (m *Matchbot) adminDone(w http.ResponseWriter, r *http.Request, name string, err error) {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event":  "matchbot.ServeAdmin",
+			"method": r.Method,
+			"queue":  name,
+			"error":  err,
+		}).Error("admin request failed")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"event":  "matchbot.ServeAdmin",
+		"method": r.Method,
+		"queue":  name,
+	}).Info("admin request done")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package matchbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminQueues(t *testing.T) {
+	m := newTestMatchbot(t)
+	def := testDefinition(t, "1v1")
+	mustHostQueue(t, m, def)
+	m.do(func() {
+		m.players["bob"] = m.queues["1v1"]
+	})
+
+	body, err := json.Marshal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/queues", "", http.StatusMethodNotAllowed},
+		{"POST", "/queues", `{"name": "broken"`, http.StatusBadRequest},
+		{"POST", "/queues", `{"name": "nomaps", "minPlayers": 2, "maxPlayers": 2}`, http.StatusBadRequest},
+		{"POST", "/queues", string(body), http.StatusConflict},
+		{"PUT", "/queues/2v2", string(body), http.StatusNotFound},
+		{"PUT", "/queues/1v1", strings.Replace(string(body), `"1v1"`, `"2v2"`, -1), http.StatusBadRequest},
+		{"PATCH", "/queues/1v1", "", http.StatusMethodNotAllowed},
+		{"DELETE", "/queues/2v2", "", http.StatusNotFound},
+		// closing works locally; telling the server fails, as there isn't one
+		{"DELETE", "/queues/1v1?reason=maintenance", "", http.StatusBadGateway},
+		{"DELETE", "/queues/1v1", "", http.StatusNotFound},
+	}
+
+	handler := m.adminHandler()
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != c.status {
+			t.Errorf("%v %v: got %v (%v), want %v", c.method, c.path, res.Code, strings.TrimSpace(res.Body.String()), c.status)
+		}
+	}
+
+	m.do(func() {
+		if _, ok := m.players["bob"]; ok {
+			t.Errorf("bob wasn't released when 1v1 closed")
+		}
+	})
+}
+
+// an update the queue can't take in place must not reach the server as one:
+// a new script restarts the queue, and a bad interval is refused outright.
+func TestAdminUpdateQueue(t *testing.T) {
+	m := newTestMatchbot(t)
+	l := connectLobby(t, m)
+	def := testDefinition(t, "1v1")
+	old := mustHostQueue(t, m, def)
+	m.do(func() {
+		m.players["bob"] = old
+	})
+
+	broken := *def
+	broken.UpdateInterval = "soon"
+	if err := m.UpdateQueue(&broken); err == nil {
+		t.Errorf("updated 1v1 to an updateInterval of %q", broken.UpdateInterval)
+	}
+
+	rescripted := testDefinition(t, "1v1")
+	body, err := json.Marshal(rescripted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest("PUT", "/queues/1v1", bytes.NewReader(body))
+		m.adminHandler().ServeHTTP(res, req)
+	}()
+
+	l.waitForSent(t, "OPENQUEUE", 1)
+	l.say(t, `QUEUEOPENED {"name": "1v1"}`)
+	<-done
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("PUT /queues/1v1 with a new script: got %v (%v)", res.Code, strings.TrimSpace(res.Body.String()))
+	}
+
+	if updates := l.sent("UPDATEQUEUE"); len(updates) != 0 {
+		t.Errorf("sent %v for updates the queue couldn't take", updates)
+	}
+	if closes := l.waitForSent(t, "CLOSEQUEUE", 1); len(closes) != 1 {
+		t.Errorf("want 1v1 closed once to restart it, got %v", closes)
+	}
+
+	var q *queue.Queue
+	m.do(func() {
+		q = m.queues["1v1"]
+		if _, ok := m.players["bob"]; ok {
+			t.Errorf("bob is still queued in the restarted 1v1")
+		}
+	})
+	if q == nil || q == old {
+		t.Fatalf("1v1 wasn't restarted")
+	}
+	if got := q.Definition().LuaFile; got != rescripted.LuaFile {
+		t.Errorf("restarted 1v1 runs %v, want %v", got, rescripted.LuaFile)
+	}
+}
@@ -0,0 +1,139 @@
+package matchbot
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/spring/lobby/client"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+// DefaultQueuesPoll is how often the queues file is checked for changes.
+// polling rather than inotify: it behaves the same on every OS and
+// filesystem, and a queue catalogue doesn't change often.
+const DefaultQueuesPoll = 5 * time.Second
+
+func loadQueues(file string) ([]*queue.Definition, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("matchbot.loadQueues: could not read %v: %v", file, err)
+	}
+
+	defs, err := queue.ParseDefinitions(b)
+	if err != nil {
+		return nil, fmt.Errorf("matchbot.loadQueues: %v is invalid: %v", file, err)
+	}
+	return defs, nil
+}
+
+// reconcileQueues brings the running queues in line with the queues file:
+// new entries are opened, missing ones closed, and changed ones updated. if
+// the file can't be loaded, nothing is touched.
+func (m *Matchbot) reconcileQueues() error {
+	m.reconcileMut.Lock()
+	defer m.reconcileMut.Unlock()
+
+	defs, err := loadQueues(m.queuesFile)
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]*queue.Definition{}
+	for _, def := range defs {
+		wanted[def.Name] = def
+	}
+
+	running := map[string]*queue.Queue{}
+	m.do(func() {
+		for name, q := range m.queues {
+			running[name] = q
+		}
+	})
+
+	for name := range running {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+
+		err := m.CloseQueue(name, "this queue has been retired")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.reconcileQueues",
+				"queue": name,
+				"error": err,
+			}).Error("could not close queue")
+		}
+	}
+
+	for name, def := range wanted {
+		q, ok := running[name]
+		if !ok {
+			err = m.OpenQueue(def)
+		} else if !reflect.DeepEqual(q.Definition(), def) {
+			// a change of script restarts the queue
+			err = m.UpdateQueue(def)
+		} else {
+			continue
+		}
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.reconcileQueues",
+				"queue": name,
+				"error": err,
+			}).Error("could not apply queue definition")
+		}
+	}
+	return nil
+}
+
+// watchQueues reconciles the running queues whenever the queues file
+// changes. while logged out there is nothing to reconcile: LOGININFOEND
+// catches up on whatever happened in the meantime.
+func (m *Matchbot) watchQueues() {
+	ticker := m.clock.NewTicker(m.queuesPoll)
+	defer ticker.Stop()
+
+	var modified time.Time
+	var size int64
+	for {
+		select {
+		case <-ticker.C():
+		case <-m.shutdown:
+			return
+		}
+
+		info, err := os.Stat(m.queuesFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.watchQueues",
+				"file":  m.queuesFile,
+				"error": err,
+			}).Warn("could not check queues file")
+			continue
+		}
+
+		if info.ModTime().Equal(modified) && info.Size() == size {
+			continue
+		}
+
+		if m.client.LoginState() != client.LoggedIn {
+			continue
+		}
+
+		modified = info.ModTime()
+		size = info.Size()
+
+		err = m.reconcileQueues()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.watchQueues",
+				"file":  m.queuesFile,
+				"error": err,
+			}).Error("queues file is broken, keeping the queues that are running")
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package clock lets timing-dependent code run on real time in production,
+// and on a Fake clock that only moves when told to in tests and simulations.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the subset of the time package the matchbot uses.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer from a Clock. After is fine for waits which always
+// run their course; a wait which can be cut short should use a Timer and stop
+// it, or the clock holds on to it until it would have fired.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a time.Ticker from a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Fake is a Clock which stands still until Advance is called. like the time
+// package, its tickers drop ticks nobody is reading.
+type Fake struct {
+	mut     sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	c     chan time.Time
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	next   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFake gets you a fake clock reading start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	timer := &fakeTimer{
+		clock: f,
+		at:    f.now.Add(d),
+		c:     make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		timer.c <- f.now
+		return timer
+	}
+
+	f.timers = append(f.timers, timer)
+	return timer
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	ticker := &fakeTicker{
+		clock:  f,
+		next:   f.now.Add(d),
+		period: d,
+		c:      make(chan time.Time, 1),
+	}
+	f.tickers = append(f.tickers, ticker)
+	return ticker
+}
+
+// Advance moves the clock forward, firing every timer and ticker that comes
+// due on the way, in the order they come due. each fires with the time it was
+// due, and Now reads that time while it fires. Advance doesn't wait for anyone
+// to react to them.
+func (f *Fake) Advance(d time.Duration) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		timer, ticker, at := f.nextDue()
+		if timer == nil && ticker == nil || at.After(end) {
+			break
+		}
+		f.now = at
+
+		if timer != nil {
+			f.removeTimer(timer)
+			timer.c <- at
+			continue
+		}
+
+		select {
+		case ticker.c <- at:
+		default:
+		}
+		ticker.next = at.Add(ticker.period)
+	}
+	f.now = end
+}
+
+// nextDue finds whichever timer or ticker is due first. timers win ties, and
+// otherwise the one created first does.
+func (f *Fake) nextDue() (*fakeTimer, *fakeTicker, time.Time) {
+	var (
+		timer  *fakeTimer
+		ticker *fakeTicker
+		at     time.Time
+	)
+
+	for _, t := range f.timers {
+		if timer == nil || t.at.Before(at) {
+			timer, at = t, t.at
+		}
+	}
+
+	for _, t := range f.tickers {
+		if (timer == nil && ticker == nil) || t.next.Before(at) {
+			timer, ticker, at = nil, t, t.next
+		}
+	}
+	return timer, ticker, at
+}
+
+func (f *Fake) removeTimer(t *fakeTimer) bool {
+	for i, timer := range f.timers {
+		if timer == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Waiters is how many timers and tickers are pending, so a test can tell
+// when the code under test has started waiting.
+func (f *Fake) Waiters() int {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return len(f.timers) + len(f.tickers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop is true if it stopped the timer, and false if it had already fired or
+// been stopped.
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.removeTimer(t)
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	f := t.clock
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	for i, ticker := range f.tickers {
+		if ticker == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
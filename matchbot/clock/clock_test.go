@@ -0,0 +1,149 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeAdvanceOrder(t *testing.T) {
+	f := NewFake(epoch)
+
+	// created out of order: each must fire once, with its own time
+	late := f.After(5 * time.Second)
+	early := f.After(1 * time.Second)
+	middle := f.NewTimer(3 * time.Second)
+	ticker := f.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	f.Advance(4 * time.Second)
+
+	fired := []struct {
+		name string
+		c    <-chan time.Time
+		at   time.Duration
+	}{
+		{"early", early, 1 * time.Second},
+		{"middle", middle.C(), 3 * time.Second},
+	}
+	for _, timer := range fired {
+		select {
+		case at := <-timer.c:
+			if want := epoch.Add(timer.at); !at.Equal(want) {
+				t.Errorf("%v fired at %v, not its due time %v", timer.name, at, want)
+			}
+		default:
+			t.Errorf("%v did not fire", timer.name)
+		}
+	}
+
+	select {
+	case at := <-late:
+		t.Errorf("late fired early, at %v", at)
+	default:
+	}
+
+	// the tick at 2s was never read, so the one at 4s was dropped
+	select {
+	case at := <-ticker.C():
+		if !at.Equal(epoch.Add(2 * time.Second)) {
+			t.Errorf("ticker delivered %v, want the first tick", at)
+		}
+	default:
+		t.Error("ticker did not tick")
+	}
+
+	if now := f.Now(); !now.Equal(epoch.Add(4 * time.Second)) {
+		t.Errorf("clock reads %v after advancing 4s", now)
+	}
+
+	f.Advance(time.Second)
+	select {
+	case at := <-late:
+		if !at.Equal(epoch.Add(5 * time.Second)) {
+			t.Errorf("late fired at %v", at)
+		}
+	default:
+		t.Error("late did not fire")
+	}
+
+	if f.Waiters() != 1 {
+		t.Errorf("only the ticker should be left, have %d waiters", f.Waiters())
+	}
+}
+
+// a ticker which is kept up with delivers every tick, each with its own time.
+func TestFakeTickerSteps(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+
+	seen := make(chan time.Time, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case at := <-ticker.C():
+				seen <- at
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// ticks nobody has read yet are dropped, so go one at a time
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		at := <-seen
+		if want := epoch.Add(time.Duration(i) * time.Second); !at.Equal(want) {
+			t.Errorf("tick %d at %v, want %v", i, at, want)
+		}
+	}
+	close(stop)
+	<-done
+
+	ticker.Stop()
+	f.Advance(time.Hour)
+	select {
+	case at := <-ticker.C():
+		t.Errorf("stopped ticker ticked at %v", at)
+	default:
+	}
+}
+
+func TestFakeTimerStop(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(time.Second)
+
+	if f.Waiters() != 1 {
+		t.Fatalf("want 1 waiter, have %d", f.Waiters())
+	}
+
+	if !timer.Stop() {
+		t.Error("Stop should report stopping a pending timer")
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("stopped timer is still waiting")
+	}
+	if timer.Stop() {
+		t.Error("Stop should report the timer was already stopped")
+	}
+
+	f.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+
+	fired := f.NewTimer(time.Second)
+	f.Advance(time.Second)
+	if fired.Stop() {
+		t.Error("Stop should report the timer had already fired")
+	}
+	if _, ok := <-fired.C(); !ok {
+		t.Error("fired timer's channel was closed")
+	}
+}
@@ -0,0 +1,103 @@
+package matchbot
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+)
+
+// registerCommands wires up every server command the matchbot understands.
+// a new command needs exactly one Handle call here.
+func (m *Matchbot) registerCommands() *protocol.Registry {
+	r := protocol.NewRegistry()
+
+	// commands we don't care about
+	r.Ignore(
+		"TASServer",
+		"MOTD",
+		"PONG",
+		// the client keeps its user registry up to date from these itself
+		"ADDUSER",
+		"CLIENTSTATUS",
+		"OPENQUEUE",
+	)
+
+	// login flow: these are all replies to Login, which handles them
+	r.Ignore("ACCEPTED", "DENIED", "AGREEMENT", "AGREEMENTEND")
+	// we never register an account, so these mean something is badly off
+	for _, command := range []string{"REGISTRATIONACCEPTED", "REGISTRATIONDENIED"} {
+		command := command
+		r.HandleText(command, func(data string) {
+			log.WithFields(log.Fields{
+				"event":   "matchbot.handleServerCommands",
+				"command": command,
+				"data":    data,
+			}).Warn("registration reply, but the matchbot never registers")
+		})
+	}
+	r.HandleText("LOGININFOEND", func(string) {
+		// in the background: opening a queue waits on the server's reply, and
+		// meanwhile this goroutine has to keep draining events
+		go func() {
+			err := m.reconcileQueues()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event": "matchbot.LOGININFOEND",
+					"file":  m.queuesFile,
+					"error": err,
+				}).Error("could not open queues from the queues file")
+			}
+		}()
+	})
+
+	// matchmaking commands
+	protocol.HandlePartial(r, "JOINQUEUEREQUEST", m.addPlayers, func(msg *protocol.JoinQueueRequest, err error) {
+		// whatever decoded is our best guess at who to tell
+		m.denyJoin(
+			msg.Name,
+			msg.UserNames,
+			fmt.Sprintf("matchbot choked on JOINQUEUEREQUEST from server. contact an admin! error: %v", err),
+		)
+	})
+	protocol.Handle(r, "QUEUELEFT", m.removePlayers)
+	r.HandleText("REMOVEUSER", m.removeUser)
+	protocol.Handle(r, "READYCHECKRESPONSE", m.readyCheckResponse)
+
+	// the replies to OpenQueue and UpdateQueue
+	r.Ignore("QUEUEOPENED", "QUEUEUPDATED")
+
+	// chit chat
+	r.HandleText("SERVERMSG", func(data string) {
+		log.WithFields(log.Fields{
+			"event":   "matchbot.handleServerCommands",
+			"command": "SERVERMSG",
+			"data":    data,
+		}).Info("server message")
+	})
+	r.HandleText("FAILED", func(data string) {
+		log.WithFields(log.Fields{
+			"event":   "matchbot.handleServerCommands",
+			"command": "FAILED",
+			"data":    data,
+		}).Error("failed command")
+	})
+
+	// confusing commands
+	r.Unknown = func(msg *protocol.Message) {
+		log.WithFields(log.Fields{
+			"event":   "matchbot.handleServerCommands",
+			"command": msg.Command,
+			"data":    string(msg.Data),
+		}).Warn("unknown server command")
+	}
+	r.Malformed = func(msg *protocol.Message, err error) {
+		log.WithFields(log.Fields{
+			"event":   "matchbot.handleServerCommands",
+			"command": msg.Command,
+			"data":    string(msg.Data),
+			"error":   err,
+		}).Error("could not decode server command")
+	}
+
+	return r
+}
@@ -9,6 +9,9 @@ import (
 	"time"
 )
 
+// how many ready check responses may wait for a spinner to read them
+const readyCheckBuffer = 64
+
 func (m *Matchbot) matchesToGames() {
 	for {
 		select {
@@ -18,16 +21,37 @@ func (m *Matchbot) matchesToGames() {
 				playerNames[i] = player.Name
 			}
 			// TODO: configurable timeout for user ready check
-			m.client.ReadyCheck(match.QueueName, playerNames, 10)
+			err := m.client.ReadyCheck(match.QueueName, playerNames, 10)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event":    "matchbot.matchesToGames",
+					"queue":    match.QueueName,
+					"match_id": match.Id,
+					"error":    err,
+				}).Error("could not send ready check")
+
+				// nobody was asked, so nobody will answer. the server is out
+				// of reach, and forgets who was queued when we reconnect:
+				// better the players hear it now than get matched again into
+				// the same failure
+				m.readyCheckFailed(match, fmt.Sprintf("could not send the ready check: %v", err), playerNames)
+				continue
+			}
 
 			// Spawn a goroutine to represent this match.
-			m.readyMut.Lock()
-			m.readyID++
-			ch := make(chan *protocol.ReadyCheckResponse)
-			m.ready[m.readyID] = ch
+			var id uint32
+			ch := make(chan *protocol.ReadyCheckResponse, readyCheckBuffer)
+			ok := m.do(func() {
+				m.readyID++
+				id = m.readyID
+				m.ready[id] = ch
+			})
+			if !ok {
+				return
+			}
+
 			// The goroutine will exit when m.shutdown is closed.
-			go m.readyCheckSpinner(m.readyID, match, ch)
-			m.readyMut.Unlock()
+			go m.readyCheckSpinner(id, match, ch)
 
 		case <-m.shutdown:
 			return
@@ -52,6 +76,11 @@ func (m *Matchbot) readyCheckSpinner(id uint32, match *queue.Match, ch chan *pro
 		"players":  playerNames,
 	}).Info("Entering readyCheck spinner")
 
+	// the same 10 seconds the server was told about, counted from here
+	// rather than from the last response
+	timeout := m.clock.NewTimer(10 * time.Second)
+	defer timeout.Stop()
+
 Listen:
 	for {
 		select {
@@ -76,12 +105,9 @@ Listen:
 			}).Debug("got a readycheck response")
 
 			if readyCheck.Response != "ready" {
-				m.client.ReadyCheckResult(
-					match.QueueName,
-					playerNames,
-					fmt.Sprintf("%s responded with status %s", readyCheck.UserName, readyCheck.Response),
-				)
-
+				reason := fmt.Sprintf("%s responded with status %s", readyCheck.UserName, readyCheck.Response)
+				m.readyCheckResult(match, playerNames, reason)
+				m.readyCheckFailed(match, reason, []string{readyCheck.UserName})
 				break Listen
 			}
 
@@ -99,13 +125,9 @@ Listen:
 					"players":  playerNames,
 				}).Info("ready check complete, starting game")
 
-				m.client.ReadyCheckResult(
-					match.QueueName,
-					playerNames,
-					"pass",
-				)
+				m.readyCheckResult(match, playerNames, "pass")
 
-				g := game.New(match)
+				g := game.New(match, m.engines, m.clock)
 
 				err := g.Start()
 				if err != nil {
@@ -116,50 +138,206 @@ Listen:
 						"error":    err,
 					}).Error("failure to start game!")
 
-					m.client.ReadyCheckResult(
-						match.QueueName,
-						playerNames,
-						"fail",
-					)
+					m.readyCheckResult(match, playerNames, "fail")
+
+					// whatever broke would break again for the same players
+					m.readyCheckFailed(match, fmt.Sprintf("game failed to start: %v", err), playerNames)
 					break Listen
 				}
 
 				log.WithFields(log.Fields{
-					"event":    "matchbot.readyCheckSpinner",
-					"queue":    match.QueueName,
-					"match_id": match.Id,
-					"players":  g.Script.Players,
+					"event":       "matchbot.readyCheckSpinner",
+					"queue":       match.QueueName,
+					"match_id":    match.Id,
+					"players":     g.Script.Players,
+					"map":         match.Map,
+					"map_reason":  match.MapReason,
+					"game":        match.Game,
+					"game_reason": match.GameReason,
 				}).Info("game started, connecting players")
 
+				unconnected := []string{}
+				var connectErr error
 				for _, p := range g.Script.Players {
-					m.client.ConnectUser(p.Name, g.Script.IP, g.Script.Port, p.Password, g.Script.Engine)
+					err := m.client.ConnectUser(p.Name, g.Script.IP, g.Script.Port, p.Password, g.Script.Engine)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"event":    "matchbot.readyCheckSpinner",
+							"queue":    match.QueueName,
+							"match_id": match.Id,
+							"user":     p.Name,
+							"error":    err,
+						}).Error("could not send player the game's address")
+						unconnected = append(unconnected, p.Name)
+						connectErr = err
+					}
+				}
+
+				// a game nobody can find is no game at all
+				if len(unconnected) == len(g.Script.Players) {
+					err = g.Shutdown()
+					if err != nil {
+						log.WithFields(log.Fields{
+							"event":    "matchbot.readyCheckSpinner",
+							"queue":    match.QueueName,
+							"match_id": match.Id,
+							"error":    err,
+						}).Warn("could not shut down game nobody was sent to")
+					}
+					go g.Wait()
+
+					m.readyCheckFailed(match, fmt.Sprintf("could not send anyone the game's address: %v", connectErr), playerNames)
+					break Listen
+				}
+
+				if len(unconnected) > 0 {
+					log.WithFields(log.Fields{
+						"event":       "matchbot.readyCheckSpinner",
+						"queue":       match.QueueName,
+						"match_id":    match.Id,
+						"unconnected": unconnected,
+					}).Warn("game started without the players who weren't sent its address")
+				}
+
+				q := m.queueFor(match)
+				if q != nil {
+					err = q.MatchStarted(match)
+					if err != nil {
+						log.WithFields(log.Fields{
+							"event":    "matchbot.readyCheckSpinner",
+							"queue":    match.QueueName,
+							"match_id": match.Id,
+							"error":    err,
+						}).Warn("could not tell the queue its match started")
+					}
 				}
 
-				go m.manageGame(g)
+				go m.manageGame(match, g)
 				break Listen
 			}
 
 		case <-m.shutdown:
 			break Listen
-		case <-time.After(10 * time.Second):
+		case <-timeout.C():
 			log.Info("a ready check timed out")
-			m.client.ReadyCheckResult(
-				match.QueueName,
-				playerNames,
-				"timeout waiting for players to ready up",
-			)
+			reason := "timeout waiting for players to ready up"
+			m.readyCheckResult(match, playerNames, reason)
+
+			unready := []string{}
+			for name, readied := range playerReadyStatus {
+				if !readied {
+					unready = append(unready, name)
+				}
+			}
 
+			m.readyCheckFailed(match, reason, unready)
 			break Listen
 		}
 	}
 
-	m.readyMut.Lock()
-	delete(m.ready, id)
-	m.readyMut.Unlock()
+	m.do(func() {
+		delete(m.ready, id)
+	})
 }
 
-func (m *Matchbot) manageGame(g *game.Game) {
-	g.Wait()
+// readyCheckResult tells the server how a ready check went.
+func (m *Matchbot) readyCheckResult(match *queue.Match, players []string, result string) {
+	err := m.client.ReadyCheckResult(match.QueueName, players, result)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event":    "matchbot.readyCheckResult",
+			"queue":    match.QueueName,
+			"match_id": match.Id,
+			"result":   result,
+			"error":    err,
+		}).Warn("could not send ready check result")
+	}
+}
+
+// queueFor finds the queue a match came from, if it's still open.
+func (m *Matchbot) queueFor(match *queue.Match) *queue.Queue {
+	var q *queue.Queue
+	m.do(func() {
+		q = m.queues[match.QueueName]
+	})
+	return q
+}
+
+// readyCheckFailed hands a match which fell through back to its queue, which
+// drops the players named in dropped. they're told why.
+func (m *Matchbot) readyCheckFailed(match *queue.Match, reason string, dropped []string) {
+	q := m.queueFor(match)
+	if q == nil {
+		return
+	}
+
+	err := q.ReadyCheckFailed(match, reason, dropped)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event":    "matchbot.readyCheckFailed",
+			"queue":    match.QueueName,
+			"match_id": match.Id,
+			"error":    err,
+		}).Warn("could not tell the queue its ready check failed")
+		return
+	}
+
+	released := []string{}
+	m.do(func() {
+		for _, player := range dropped {
+			if m.players[player] == q {
+				delete(m.players, player)
+				released = append(released, player)
+			}
+		}
+	})
+
+	for _, player := range released {
+		err := m.client.SayPrivate(player, fmt.Sprintf("you have left queue %v: %v", match.QueueName, reason))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.readyCheckFailed",
+				"queue": match.QueueName,
+				"user":  player,
+				"error": err,
+			}).Warn("could not tell a dropped player why")
+		}
+	}
+}
+
+func (m *Matchbot) manageGame(match *queue.Match, g *game.Game) {
+	started := m.clock.Now()
+	err := g.Wait()
+
+	result := queue.MatchResult{
+		Duration: m.clock.Now().Sub(started),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	q := m.queueFor(match)
+	if q != nil {
+		err = q.MatchEnded(match, result)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event":    "matchbot.manageGame",
+				"queue":    match.QueueName,
+				"match_id": match.Id,
+				"error":    err,
+			}).Warn("could not tell the queue its match ended")
+		}
+	}
+
+	// the players are free to queue again
+	m.do(func() {
+		for _, p := range match.Players {
+			if current, ok := m.players[p.Name]; ok && current == q {
+				delete(m.players, p.Name)
+			}
+		}
+	})
+
 	// TODO:
 	/*
 		//
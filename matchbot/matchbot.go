@@ -1,13 +1,15 @@
 package matchbot
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/clock"
 	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/matchbot/store"
+	"github.com/kanatohodets/go-match/spring/game"
 	"github.com/kanatohodets/go-match/spring/lobby/client"
 	"github.com/kanatohodets/go-match/spring/lobby/protocol"
-	"io/ioutil"
 	"sync"
 	"time"
 )
@@ -17,173 +19,299 @@ type Matchbot struct {
 	// protects against races from SIGINT and the reconnection loop with m.client
 	client *client.Client
 
+	// spring-dedicated binaries for every engine version our queues use
+	engines game.Engines
+
+	clock clock.Clock
+
+	// state the queue scripts keep across restarts
+	store store.Store
+
 	shutdown chan struct{}
 
-	queueMut sync.Mutex
-	queues   map[string]*queue.Queue
-	players  map[string]*queue.Queue
+	commands *protocol.Registry
+
+	// the queue catalogue, kept in sync with the running queues; see catalogue.go
+	queuesFile   string
+	queuesPoll   time.Duration
+	reconcileMut sync.Mutex
+
+	reconnect    Backoff
+	observersMut sync.Mutex
+	observers    []func(ConnectionEvent)
+	// what the connection events add up to, for Status
+	connectionMut sync.Mutex
+	connection    ConnectionStatus
+
+	// work for the state goroutine; see state.go
+	actions chan func()
+
+	// owned by the state goroutine
+	queues  map[string]*queue.Queue
+	players map[string]*queue.Queue
+	// hosted queues run until the session ends; see resetSession
+	session    context.Context
+	endSession context.CancelFunc
 
 	matches chan *queue.Match
 
-	readyMut sync.RWMutex
+	// owned by the state goroutine.
 	// this will be a bug if there are > 4294967295 concurrent matches in the 'readyCheckSpinner' state.
 	readyID uint32
 	ready   map[uint32]chan *protocol.ReadyCheckResponse
 }
 
+// how long the server gets to answer a request, like LOGIN or OPENQUEUE
+const requestTimeout = 30 * time.Second
+
+// Config holds the matchbot's settings.
+type Config struct {
+	// spring-dedicated binaries for every engine version our queues use
+	Engines game.Engines
+	Client  client.Config
+	// delays between attempts to reach the server; DefaultBackoff if empty
+	Reconnect Backoff
+	// how often to check the queues file for changes; DefaultQueuesPoll if zero
+	QueuesPoll time.Duration
+	// drives queue updates, ready check timeouts and reconnect delays;
+	// clock.Real if nil
+	Clock clock.Clock
+	// where queue scripts keep state across restarts; nothing is kept if nil
+	Store store.Store
+}
+
 // New gets you a fresh matchbot. only expected to be called once per program run.
-func New() *Matchbot {
-	return &Matchbot{
+func New(config Config) *Matchbot {
+	if config.Reconnect.Max == 0 {
+		config.Reconnect = DefaultBackoff
+	}
+
+	if config.QueuesPoll == 0 {
+		config.QueuesPoll = DefaultQueuesPoll
+	}
+
+	if config.Clock == nil {
+		config.Clock = clock.Real
+	}
+
+	if config.Store == nil {
+		config.Store = store.NewMemory()
+	}
+
+	m := &Matchbot{
+		engines:    config.Engines,
+		reconnect:  config.Reconnect,
+		queuesPoll: config.QueuesPoll,
+		clock:      config.Clock,
+		store:      config.Store,
+
 		queues:  make(map[string]*queue.Queue),
 		players: make(map[string]*queue.Queue),
 
+		actions:  make(chan func()),
 		matches:  make(chan *queue.Match),
 		shutdown: make(chan struct{}),
 
-		client: client.New(),
+		client: client.New(config.Client),
 
 		ready: make(map[uint32]chan *protocol.ReadyCheckResponse),
 	}
-}
 
-// Start starts and maintains a matchbot's connection to the spring server
-func (m *Matchbot) Start(server string, user string, password string, queuesFile string) {
+	m.session, m.endSession = context.WithCancel(context.Background())
+	m.commands = m.registerCommands()
+	m.OnConnection(m.recordConnection)
+
 	// this goroutine will exit when m.shutdown is closed
+	go m.run()
+	return m
+}
+
+// Start starts and maintains a matchbot's connection to the spring server,
+// hosting the queues defined in queuesFile. it returns nil once Shutdown is called, or an error if the server refuses
+// the login in a way that retrying won't fix (bad password, ban, etc.).
+func (m *Matchbot) Start(server string, user string, password string, queuesFile string) error {
+	m.queuesFile = queuesFile
+
+	// these goroutines will exit when m.shutdown is closed
 	go m.matchesToGames()
+	go m.watchQueues()
+
+	backoff := m.reconnect
+	attempt := 0
 
-	// infinite loop so there's a reconnect if the server shuts off
+	// loop so there's a reconnect if the server shuts off
 	for {
+		attempt++
+		loggedIn := false
 		err := m.client.Connect(server)
 		if err == nil {
+			m.notifyConnection(ConnectionEvent{
+				Attempt:   attempt,
+				Connected: true,
+			})
+
+			m.resetSession()
+
 			// this goroutine will exit when the client terminates
 			go m.handleServerCommands(m.client.Events)
-			m.client.Login(user, password)
+
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			err = m.client.Login(ctx, user, password)
+			cancel()
+			loggedIn = err == nil
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event": "matchbot.Start",
+					"state": m.client.LoginState(),
+					"error": err,
+				}).Error("could not log in")
+				m.client.Disconnect()
+
+				loginErr, ok := err.(*client.LoginError)
+				if ok && loginErr.Permanent() {
+					return err
+				}
+			} else {
+				log.WithFields(log.Fields{
+					"event": "matchbot.Start",
+				}).Info("successfully logged in")
+			}
 
 			// blocks until the client terminates
 			m.client.Done()
 			log.Info("client closed connection")
+
+			// the server let us in, so it's healthy again: the next
+			// attempt starts over from short delays
+			if loggedIn {
+				backoff.Reset()
+			}
+			err = fmt.Errorf("connection closed")
 		} else {
 			log.WithFields(log.Fields{
-				"event": "matchbot.Start",
-				"error": err,
+				"event":   "matchbot.Start",
+				"attempt": attempt,
+				"error":   err,
 			}).Info("client could not connect to spring server")
 		}
 
-		time.Sleep(10 * time.Second)
+		delay := backoff.Next()
+		m.notifyConnection(ConnectionEvent{
+			Attempt: attempt,
+			Err:     err,
+			Delay:   delay,
+		})
+		if loggedIn {
+			attempt = 0
+		}
+
+		select {
+		case <-m.clock.After(delay):
+		case <-m.shutdown:
+			return nil
+		}
 	}
 }
 
 // Shutdown cleanly terminates the matchbot, closing all hosted queues and gracefully exiting from the spring server
 func (m *Matchbot) Shutdown() {
-	close(m.shutdown)
-	if m.client.Active() {
-		m.queueMut.Lock()
-		for name, _ := range m.queues {
-			m.client.CloseQueue(name)
+	queues := map[string]*queue.Queue{}
+	m.do(func() {
+		// stops every queue, including any still on their way into m.queues
+		m.endSession()
+		for name, q := range m.queues {
+			queues[name] = q
 		}
-		m.queueMut.Unlock()
+	})
 
-		m.client.Disconnect()
-	}
-}
+	close(m.shutdown)
 
-func (m *Matchbot) handleServerCommands(events chan *protocol.Message) {
-	for msg := range events {
-		switch msg.Command {
+	for name, q := range queues {
+		q.Close()
 
-		// commands we don't care about
-		case "TASServer":
-			continue
-		case "MOTD":
+		if !m.client.Active() {
 			continue
-		case "PONG":
-			continue
-		case "ADDUSER":
-			continue
-		case "CLIENTSTATUS":
-			continue
-		case "OPENQUEUE":
-			continue
-
-		// login flow
-		case "ACCEPTED":
-			log.WithFields(log.Fields{
-				"event": "matchbot.handleServerCommands",
-			}).Info("successfully logged in")
-		case "LOGININFOEND":
-			//TODO(btyler) config-ify filename; save in KV store, set in web interface???
-			m.openStaticQueues("example/queue.json")
-
-		// matchmaking commands
-		case "JOINQUEUEREQUEST":
-			m.addPlayers(msg.Data)
-		case "QUEUELEFT":
-			m.removePlayers(msg.Data)
-		case "REMOVEUSER":
-			player := string(msg.Data)
-			queue, ok := m.players[player]
-			if ok {
-				queue.RemovePlayer(player)
-			}
-		case "READYCHECKRESPONSE":
-			m.readyCheckResponse(msg.Data)
-
-		// TODO open earlier to avoid racing clients who try to join?
-		case "QUEUEOPENED":
-			m.addQueue(msg.Data)
-
-		// chit chat
-		case "SERVERMSG":
-			log.WithFields(log.Fields{
-				"event":   "matchbot.handleServerCommands",
-				"command": msg.Command,
-				"data":    string(msg.Data),
-			}).Info("server message")
+		}
 
-		case "FAILED":
+		err := m.client.CloseQueue(name, "the matchbot is shutting down")
+		if err != nil {
 			log.WithFields(log.Fields{
-				"event":   "matchbot.handleServerCommands",
-				"command": msg.Command,
-				"data":    string(msg.Data),
-			}).Error("failed command")
+				"event": "matchbot.Shutdown",
+				"queue": name,
+				"error": err,
+			}).Warn("could not close queue")
+		}
+	}
 
-		// confusing commands
-		default:
+	if m.client.Active() {
+		err := m.client.Disconnect()
+		if err != nil {
 			log.WithFields(log.Fields{
-				"event":   "matchbot.handleServerCommands",
-				"command": msg.Command,
-				"data":    string(msg.Data),
-			}).Warn("unknown server command")
+				"event": "matchbot.Shutdown",
+				"error": err,
+			}).Warn("could not say goodbye to the server")
 		}
 	}
 }
 
-func (m *Matchbot) addPlayers(raw []byte) {
-	var msg protocol.JoinQueueRequest
-	err := json.Unmarshal(raw, &msg)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event": "matchbot.addPlayers",
-			"data":  string(raw),
-			"error": err,
-		}).Error("could not unmarshal join queue request")
+// handleServerCommands runs the handlers one message at a time, in order.
+// they run on this goroutine, not the state goroutine: a slow queue script or
+// a full outbound buffer holds up the server's messages, but not everyone
+// else. handlers use do for the matchbot's state.
+func (m *Matchbot) handleServerCommands(events chan *protocol.Message) {
+	for msg := range events {
+		m.commands.Dispatch(msg)
+	}
+}
 
-		m.client.JoinQueueDeny(
-			msg.Name,
-			msg.UserNames,
-			fmt.Sprintf("matchbot choked on JOINQUEUEREQUEST from server. contact an admin! error: %v", err),
-		)
-		return
+func (m *Matchbot) addPlayers(msg *protocol.JoinQueueRequest) {
+	vote := queue.MapVote{
+		Preferred: msg.MapPreferences,
+		Banned:    msg.MapBans,
+	}
+
+	playing := []string{}
+	candidates := []string{}
+	for _, player := range msg.UserNames {
+		user, ok := m.client.User(player)
+		if ok && user.Status.InGame() {
+			playing = append(playing, player)
+			continue
+		}
+		candidates = append(candidates, player)
 	}
 
-	queue, ok := m.queues[msg.Name]
-	if !ok {
+	// claim the players for the queue first, so nobody else can queue them
+	// while the queue's script takes its time with them
+	var q *queue.Queue
+	doubleMatchers := map[string][]string{}
+	claimed := []string{}
+	m.do(func() {
+		q = m.queues[msg.Name]
+		if q == nil {
+			return
+		}
+
+		for _, player := range candidates {
+			current, ok := m.players[player]
+			if ok {
+				// build up a list of players who are already in a queue
+				doubleMatchers[current.Name()] = append(doubleMatchers[current.Name()], player)
+				continue
+			}
+
+			m.players[player] = q
+			claimed = append(claimed, player)
+		}
+	})
+
+	if q == nil {
 		log.WithFields(log.Fields{
 			"event":     "matchbot.addPlayer",
 			"userNames": msg.UserNames,
 			"queueName": msg.Name,
 		}).Error("got a JOIN QUEUE request for a queue I don't know about")
-		m.client.JoinQueueDeny(
+		m.denyJoin(
 			msg.Name,
 			msg.UserNames,
 			fmt.Sprintf("matchbot does not know about queue %v: something went wrong, contact the admin!", msg.Name),
@@ -191,24 +319,15 @@ func (m *Matchbot) addPlayers(raw []byte) {
 		return
 	}
 
-	doubleMatchers := map[string][]string{}
 	errored := map[error][]string{}
 	successful := []string{}
-
-	for _, player := range msg.UserNames {
-		current, ok := m.players[player]
-		if ok {
-			// build up a list of players who are already in a queue
-			doubleMatchers[current.Def.Name] = append(doubleMatchers[current.Def.Name], player)
-			continue
-		}
-
-		err := queue.AddPlayer(player)
+	for _, player := range claimed {
+		err := q.AddPlayer(player, vote)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"event": "matchbot.addPlayer",
 				"user":  player,
-				"queue": queue.Def.Name,
+				"queue": q.Name(),
 				"error": err,
 			}).Warn("could not add player to queue")
 
@@ -216,49 +335,122 @@ func (m *Matchbot) addPlayers(raw []byte) {
 			continue
 		}
 
-		m.players[player] = queue
 		successful = append(successful, player)
 	}
 
+	if len(errored) > 0 {
+		m.do(func() {
+			for _, players := range errored {
+				for _, player := range players {
+					// unless the queue was closed meanwhile, and someone
+					// else has already claimed them
+					if m.players[player] == q {
+						delete(m.players, player)
+					}
+				}
+			}
+		})
+	}
+
 	for queue, players := range doubleMatchers {
-		m.client.JoinQueueDeny(
+		m.denyJoin(
 			msg.Name,
 			players,
 			fmt.Sprintf("already waiting in %v. Leave that queue before joining another!", queue),
 		)
 	}
 
+	if len(playing) > 0 {
+		m.denyJoin(
+			msg.Name,
+			playing,
+			"already in a game. Finish it before joining a queue!",
+		)
+	}
+
 	for err, players := range errored {
-		m.client.JoinQueueDeny(
+		m.denyJoin(
 			msg.Name,
 			players,
 			fmt.Sprintf("matchbot error adding to queue! ask admin to check logs. error: %v", err),
 		)
 	}
 
-	if len(successful) > 0 {
-		m.client.JoinQueueAccept(
-			msg.Name,
-			successful,
-		)
+	if len(successful) == 0 {
+		return
+	}
+
+	err := m.client.JoinQueueAccept(msg.Name, successful)
+	if err == nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"event":     "matchbot.addPlayer",
+		"userNames": successful,
+		"queue":     q.Name(),
+		"error":     err,
+	}).Error("could not accept players into queue, dropping them")
+
+	// the server never heard they joined, so they aren't queued as far as
+	// anyone but us knows
+	dropped := []string{}
+	m.do(func() {
+		for _, player := range successful {
+			if m.players[player] == q {
+				delete(m.players, player)
+				dropped = append(dropped, player)
+			}
+		}
+	})
+
+	for _, player := range dropped {
+		err := q.RemovePlayer(player)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.addPlayer",
+				"user":  player,
+				"queue": q.Name(),
+				"error": err,
+			}).Warn("could not drop player who wasn't accepted")
+		}
 	}
 }
 
-func (m *Matchbot) removePlayers(raw []byte) {
-	var msg protocol.QueueLeft
-	err := json.Unmarshal(raw, &msg)
+// denyJoin turns players away from a queue, and tells them why.
+func (m *Matchbot) denyJoin(queueName string, players []string, reason string) {
+	err := m.client.JoinQueueDeny(queueName, players, reason)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"event": "matchbot.removePlayers",
-			"data":  string(raw),
-			"error": err,
-		}).Error("could not unmarshal remove queue request")
-
-		return
+			"event":     "matchbot.denyJoin",
+			"userNames": players,
+			"queueName": queueName,
+			"reason":    reason,
+			"error":     err,
+		}).Warn("could not turn players away from queue")
 	}
+}
+
+func (m *Matchbot) removePlayers(msg *protocol.QueueLeft) {
+	var q *queue.Queue
+	// where each player actually was, if anywhere
+	leaving := map[string]*queue.Queue{}
+	m.do(func() {
+		q = m.queues[msg.Name]
+		if q == nil {
+			return
+		}
+
+		for _, player := range msg.UserNames {
+			playerQueue, ok := m.players[player]
+			if ok {
+				leaving[player] = playerQueue
+				delete(m.players, player)
+			}
+		}
+	})
 
-	queue, ok := m.queues[msg.Name]
-	if !ok {
+	if q == nil {
 		log.WithFields(log.Fields{
 			"event":     "matchbot.removePlayers",
 			"userNames": msg.UserNames,
@@ -268,7 +460,7 @@ func (m *Matchbot) removePlayers(raw []byte) {
 	}
 
 	for _, player := range msg.UserNames {
-		playerQueue, ok := m.players[player]
+		playerQueue, ok := leaving[player]
 		if !ok {
 			log.WithFields(log.Fields{
 				"event":     "matchbot.removePlayers",
@@ -278,11 +470,11 @@ func (m *Matchbot) removePlayers(raw []byte) {
 			continue
 		}
 
-		if playerQueue != queue {
+		if playerQueue != q {
 			log.WithFields(log.Fields{
 				"event":          "matchbot.removePlayers",
 				"user":           player,
-				"playerQueue":    playerQueue.Def.Name,
+				"playerQueue":    playerQueue.Name(),
 				"requestedQueue": msg.Name,
 			}).Error("player asked to leave a different queue from the one we think she's in. bad!")
 
@@ -294,100 +486,63 @@ func (m *Matchbot) removePlayers(raw []byte) {
 				log.WithFields(log.Fields{
 					"event": "matchbot.removePlayers",
 					"user":  player,
-					"queue": playerQueue.Def.Name,
+					"queue": playerQueue.Name(),
 					"error": err,
 				}).Error("error while removing player from 'wrong' queue (mismatch case)")
 			}
 			// no continue here since it does no significant harm (one more
-			// error) to attempt to remove the player from the requested queue,
-			// plus we'd like to delete them from the 'players' map in either
-			// case.
+			// error) to attempt to remove the player from the requested queue
 		}
 
-		err := queue.RemovePlayer(player)
+		err := q.RemovePlayer(player)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"event": "matchbot.removePlayers",
 				"user":  player,
-				"queue": queue.Def.Name,
+				"queue": q.Name(),
 				"error": err,
 			}).Error("error while removing player from queue")
 		}
-
-		delete(m.players, player)
 	}
 }
 
-func (m *Matchbot) openStaticQueues(queuesFile string) {
-	b, err := ioutil.ReadFile(queuesFile)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event": "matchbot.openStaticQueues",
-			"file":  queuesFile,
-			"error": err,
-		}).Fatal("could not read static queues file")
-		return
-	}
-
-	var defs []*protocol.QueueDefinition
-	err = json.Unmarshal(b, &defs)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event": "matchbot.openStaticQueues",
-			"file":  queuesFile,
-			"error": err,
-		}).Fatal("could not decode queues file JSON")
-		return
-	}
-
-	for _, def := range defs {
-		m.client.OpenQueue(def)
-	}
-}
+// removeUser takes a player who left the server out of their queue.
+func (m *Matchbot) removeUser(player string) {
+	var q *queue.Queue
+	m.do(func() {
+		q = m.players[player]
+		delete(m.players, player)
+	})
 
-func (m *Matchbot) addQueue(raw []byte) {
-	var def protocol.QueueDefinition
-	err := json.Unmarshal(raw, &def)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event": "matchbot.addQueue",
-			"error": err,
-			"data":  string(raw),
-		}).Warn("couldn't unmarshal new queue data")
+	if q == nil {
 		return
 	}
 
-	q, err := queue.NewQueue(&def, m.matches)
+	err := q.RemovePlayer(player)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"event": "matchbot.addQueue",
-			"queue": def.Name,
+			"event": "matchbot.removeUser",
+			"user":  player,
+			"queue": q.Name(),
 			"error": err,
-		}).Warn("failed to instantiate new queue")
-		return
+		}).Error("error while removing player who left the server from queue")
 	}
-
-	m.queueMut.Lock()
-	m.queues[def.Name] = q
-	m.queueMut.Unlock()
 }
 
-func (m *Matchbot) readyCheckResponse(raw []byte) {
-	var res protocol.ReadyCheckResponse
-	err := json.Unmarshal(raw, &res)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event": "matchbot.readyCheckResponse",
-			"data":  string(raw),
-			"error": err,
-		}).Error("invalid READYCHECKRESPONSE from server")
-		return
-	}
-
-	m.readyMut.RLock()
-	// broadcast to all readyCheckSpinners
-	for _, ch := range m.ready {
-		ch <- &res
-	}
-	m.readyMut.RUnlock()
+func (m *Matchbot) readyCheckResponse(res *protocol.ReadyCheckResponse) {
+	// broadcast to all readyCheckSpinners. never block: a spinner which is
+	// already on its way out won't be reading any more.
+	m.do(func() {
+		for id, ch := range m.ready {
+			select {
+			case ch <- res:
+			default:
+				log.WithFields(log.Fields{
+					"event":    "matchbot.readyCheckResponse",
+					"ready_id": id,
+					"user":     res.UserName,
+				}).Warn("ready check spinner is not keeping up, dropped a response")
+			}
+		}
+	})
 }
@@ -0,0 +1,542 @@
+package matchbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/clock"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/matchbot/queue/queuetest"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestMatchbot gets a matchbot with no server connection: everything it
+// sends fails straight away with client.ErrNotConnected.
+func newTestMatchbot(t *testing.T) *Matchbot {
+	m := New(Config{
+		Clock: clock.NewFake(epoch),
+	})
+	t.Cleanup(m.Shutdown)
+	return m
+}
+
+// lobby stands in for the lobby server: it takes the matchbot's connection,
+// says only what a test tells it to, and keeps every line it's sent.
+type lobby struct {
+	mut   sync.Mutex
+	conn  net.Conn
+	lines []string
+}
+
+// connectLobby connects m to a new lobby, so that what m sends goes through.
+func connectLobby(t *testing.T, m *Matchbot) *lobby {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	l := &lobby{}
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+
+		l.mut.Lock()
+		l.conn = conn
+		l.mut.Unlock()
+		accepted <- nil
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			l.mut.Lock()
+			l.lines = append(l.lines, scanner.Text())
+			l.mut.Unlock()
+		}
+	}()
+
+	err = m.client.Connect(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.hangUp)
+	return l
+}
+
+// hangUp drops the matchbot's connection.
+func (l *lobby) hangUp() {
+	l.mut.Lock()
+	l.conn.Close()
+	l.mut.Unlock()
+}
+
+// say sends the matchbot a line, as the server.
+func (l *lobby) say(t *testing.T, line string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	_, err := fmt.Fprintf(l.conn, "%v\n", line)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sent lists the lines the matchbot sent starting with command.
+func (l *lobby) sent(command string) []string {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	lines := []string{}
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, command+" ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// waitForSent waits until the lobby has read n lines starting with command:
+// a send returns once the line is written, not once it's read.
+func (l *lobby) waitForSent(t *testing.T, command string, n int) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		lines := l.sent(command)
+		if len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d %v, got %v", n, command, lines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testDefinition(t *testing.T, name string) *queue.Definition {
+	return scriptedDefinition(t, name, queuetest.IdleScript)
+}
+
+func scriptedDefinition(t *testing.T, name string, source string) *queue.Definition {
+	return &queue.Definition{
+		QueueDefinition: queuetest.Queue(name),
+		LuaFile:         queuetest.Script(t, source),
+	}
+}
+
+func mustHostQueue(t *testing.T, m *Matchbot, def *queue.Definition) *queue.Queue {
+	q, err := m.hostQueue(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// players join and leave, each from their own goroutine as the server's
+// messages would, while queues close and reopen underneath them and
+// operators poll the status. run with -race.
+func TestJoinLeaveCloseStress(t *testing.T) {
+	m := newTestMatchbot(t)
+	connectLobby(t, m)
+
+	names := []string{"1v1", "2v2", "ffa"}
+	defs := map[string]*queue.Definition{}
+	for _, name := range names {
+		defs[name] = testDefinition(t, name)
+		mustHostQueue(t, m, defs[name])
+	}
+
+	var players sync.WaitGroup
+	for i := 0; i < 24; i++ {
+		players.Add(1)
+		go func(i int) {
+			defer players.Done()
+			name := fmt.Sprintf("player%d", i)
+			for round := 0; round < 30; round++ {
+				queue := names[(i+round)%len(names)]
+				m.addPlayers(&protocol.JoinQueueRequest{Name: queue, UserNames: []string{name}})
+				if round%3 == 0 {
+					m.removeUser(name)
+				} else {
+					m.removePlayers(&protocol.QueueLeft{Name: queue, UserNames: []string{name}})
+				}
+			}
+		}(i)
+	}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for round := 0; ; round++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			// the server half of closing fails without a connection; the
+			// local half is what's under test
+			name := names[round%len(names)]
+			m.CloseQueue(name, "stress test")
+			_, err := m.hostQueue(defs[name])
+			if err != nil {
+				t.Errorf("could not reopen %v: %v", name, err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Status()
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		players.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("players are stuck: deadlock?")
+	}
+	close(stop)
+	background.Wait()
+
+	m.do(func() {
+		for player, q := range m.players {
+			if m.queues[q.Name()] != q {
+				t.Errorf("%v is still in closed queue %v", player, q.Name())
+			}
+		}
+	})
+}
+
+func TestAddPlayersClaims(t *testing.T) {
+	m := newTestMatchbot(t)
+	connectLobby(t, m)
+	mustHostQueue(t, m, testDefinition(t, "1v1"))
+	mustHostQueue(t, m, testDefinition(t, "2v2"))
+
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "1v1", UserNames: []string{"bob"}})
+	// already waiting in 1v1
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "2v2", UserNames: []string{"bob", "alice"}})
+	// no such queue
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "ffa", UserNames: []string{"carol"}})
+
+	m.do(func() {
+		if q := m.players["bob"]; q == nil || q.Name() != "1v1" {
+			t.Errorf("bob should be in 1v1, is in %v", q)
+		}
+		if q := m.players["alice"]; q == nil || q.Name() != "2v2" {
+			t.Errorf("alice should be in 2v2, is in %v", q)
+		}
+		if _, ok := m.players["carol"]; ok {
+			t.Errorf("carol joined a queue which doesn't exist")
+		}
+	})
+
+	m.removeUser("bob")
+	m.do(func() {
+		if _, ok := m.players["bob"]; ok {
+			t.Errorf("bob is still queued after leaving the server")
+		}
+	})
+}
+
+// waitForWaiters waits until the code under test is waiting on n timers and
+// tickers.
+func waitForWaiters(t *testing.T, fake *clock.Fake, n int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for fake.Waiters() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d waiters, have %d", n, fake.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// makeMatch gets the pair script to match players, the way a live queue
+// would: their join triggers a TryMatch once the debounce runs out.
+func makeMatch(t *testing.T, m *Matchbot, queueName string, players ...string) *queue.Match {
+	fake := m.clock.(*clock.Fake)
+	m.addPlayers(&protocol.JoinQueueRequest{Name: queueName, UserNames: players})
+
+	waitForWaiters(t, fake, 1)
+	fake.Advance(time.Second)
+
+	select {
+	case match := <-m.matches:
+		return match
+	case <-time.After(10 * time.Second):
+		t.Fatal("no match was made")
+		return nil
+	}
+}
+
+// startReadyCheck runs a ready check for match the way matchesToGames does,
+// and closes the channel it returns once the check is over.
+func startReadyCheck(m *Matchbot, match *queue.Match) <-chan struct{} {
+	ch := make(chan *protocol.ReadyCheckResponse, readyCheckBuffer)
+	var id uint32
+	m.do(func() {
+		m.readyID++
+		id = m.readyID
+		m.ready[id] = ch
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.readyCheckSpinner(id, match, ch)
+		close(done)
+	}()
+	return done
+}
+
+func waitForReadyCheck(t *testing.T, m *Matchbot, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ready check did not finish")
+	}
+
+	m.do(func() {
+		if len(m.ready) != 0 {
+			t.Error("ready check is still registered after finishing")
+		}
+	})
+}
+
+// readyCheckFailure is what the pair script was told about the last failed
+// ready check.
+func readyCheckFailure(t *testing.T, m *Matchbot, queueName string) string {
+	failed, ok, err := m.store.Get(queueName, "failed")
+	if err != nil || !ok {
+		t.Fatalf("the script was not told the ready check failed: %v", err)
+	}
+
+	var reason string
+	err = json.Unmarshal([]byte(failed), &reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reason
+}
+
+func checkQueued(t *testing.T, m *Matchbot, queued map[string]bool) {
+	m.do(func() {
+		for player, want := range queued {
+			if _, ok := m.players[player]; ok != want {
+				t.Errorf("%v queued: %v, want %v", player, ok, want)
+			}
+		}
+	})
+}
+
+func TestReadyCheckTimeout(t *testing.T) {
+	m := newTestMatchbot(t)
+	connectLobby(t, m)
+	fake := m.clock.(*clock.Fake)
+	mustHostQueue(t, m, scriptedDefinition(t, "1v1", queuetest.PairScript))
+
+	match := makeMatch(t, m, "1v1", "alice", "bob")
+	done := startReadyCheck(m, match)
+
+	waitForWaiters(t, fake, 1)
+	fake.Advance(9 * time.Second)
+
+	select {
+	case <-done:
+		t.Fatal("ready check gave up before its 10 seconds were up")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	waitForReadyCheck(t, m, done)
+
+	want := "timeout waiting for players to ready up: alice,bob"
+	if got := readyCheckFailure(t, m, "1v1"); got != want {
+		t.Errorf("script got %q, want %q", got, want)
+	}
+	checkQueued(t, m, map[string]bool{"alice": false, "bob": false})
+}
+
+// whoever declines leaves the queue; whoever was ready goes back to waiting,
+// and is matched again.
+func TestReadyCheckDeclined(t *testing.T) {
+	m := newTestMatchbot(t)
+	connectLobby(t, m)
+	fake := m.clock.(*clock.Fake)
+	mustHostQueue(t, m, scriptedDefinition(t, "1v1", queuetest.PairScript))
+
+	match := makeMatch(t, m, "1v1", "alice", "bob")
+	done := startReadyCheck(m, match)
+
+	m.readyCheckResponse(&protocol.ReadyCheckResponse{Name: "1v1", UserName: "bob", Response: "declined"})
+	waitForReadyCheck(t, m, done)
+
+	want := "bob responded with status declined: bob"
+	if got := readyCheckFailure(t, m, "1v1"); got != want {
+		t.Errorf("script got %q, want %q", got, want)
+	}
+	checkQueued(t, m, map[string]bool{"alice": true, "bob": false})
+
+	// the failed check's TryMatch is still pending; carol joining doesn't
+	// start another
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "1v1", UserNames: []string{"carol"}})
+	waitForWaiters(t, fake, 1)
+	fake.Advance(time.Second)
+
+	select {
+	case next := <-m.matches:
+		names := []string{}
+		for _, p := range next.Players {
+			names = append(names, p.Name)
+		}
+		if len(names) != 2 || names[0] != "alice" || names[1] != "carol" {
+			t.Errorf("want alice and carol matched, got %v", names)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("alice was not matched again")
+	}
+}
+
+// a game which won't start would fail the same way for the same players: they
+// all leave the queue rather than being matched into it again and again.
+func TestGameStartFailureDropsPlayers(t *testing.T) {
+	m := newTestMatchbot(t)
+	connectLobby(t, m)
+	mustHostQueue(t, m, scriptedDefinition(t, "1v1", queuetest.PairScript))
+
+	match := makeMatch(t, m, "1v1", "alice", "bob")
+	done := startReadyCheck(m, match)
+
+	for _, player := range []string{"alice", "bob"} {
+		m.readyCheckResponse(&protocol.ReadyCheckResponse{Name: "1v1", UserName: player, Response: "ready"})
+	}
+	waitForReadyCheck(t, m, done)
+
+	// the queue picks its first engine version, which the matchbot wasn't
+	// given a binary for
+	got := readyCheckFailure(t, m, "1v1")
+	if !strings.HasPrefix(got, "game failed to start: ") || !strings.Contains(got, `engine version "103.0"`) || !strings.HasSuffix(got, ": alice,bob") {
+		t.Errorf("script got %q, want a start failure with both players dropped", got)
+	}
+	checkQueued(t, m, map[string]bool{"alice": false, "bob": false})
+}
+
+// queues belong to the session they were opened in: a new session, or
+// shutting down, stops them.
+func TestSessionEndStopsQueues(t *testing.T) {
+	m := New(Config{
+		Clock: clock.NewFake(epoch),
+	})
+
+	var session context.Context
+	m.do(func() {
+		session = m.session
+	})
+
+	old := mustHostQueue(t, m, testDefinition(t, "1v1"))
+	m.resetSession()
+
+	if session.Err() == nil {
+		t.Error("the old session's context is still live")
+	}
+	if err := old.Tick(); err != queue.ErrClosed {
+		t.Errorf("queue from the old session: want %v, got %v", queue.ErrClosed, err)
+	}
+
+	current := mustHostQueue(t, m, testDefinition(t, "1v1"))
+	if err := current.Tick(); err != nil {
+		t.Fatalf("queue from the new session is not running: %v", err)
+	}
+
+	m.Shutdown()
+
+	if err := current.Tick(); err != queue.ErrClosed {
+		t.Errorf("queue after shutdown: want %v, got %v", queue.ErrClosed, err)
+	}
+	if _, err := m.hostQueue(testDefinition(t, "2v2")); err == nil {
+		t.Error("hosted a queue after shutting down")
+	}
+}
+
+// players the server wasn't told were accepted aren't queued.
+func TestJoinUnaccepted(t *testing.T) {
+	m := newTestMatchbot(t)
+	mustHostQueue(t, m, testDefinition(t, "1v1"))
+
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "1v1", UserNames: []string{"alice"}})
+	checkQueued(t, m, map[string]bool{"alice": false})
+}
+
+// a ready check which can't be sent fails its match there and then, rather
+// than waiting out answers which will never come.
+func TestReadyCheckUnsent(t *testing.T) {
+	m := newTestMatchbot(t)
+	fake := m.clock.(*clock.Fake)
+	l := connectLobby(t, m)
+	mustHostQueue(t, m, scriptedDefinition(t, "1v1", queuetest.PairScript))
+
+	m.addPlayers(&protocol.JoinQueueRequest{Name: "1v1", UserNames: []string{"alice", "bob"}})
+	l.waitForSent(t, "JOINQUEUEACCEPT", 1)
+
+	l.hangUp()
+	m.client.Done()
+	go m.matchesToGames()
+
+	waitForWaiters(t, fake, 1)
+	fake.Advance(time.Second)
+
+	// the script hears of the failure before the players are dropped
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, failed, _ := m.store.Get("1v1", "failed")
+		queued := 0
+		m.do(func() {
+			queued = len(m.players)
+		})
+		if failed && queued == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the match was never failed: failure stored %v, %v players still queued", failed, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	got := readyCheckFailure(t, m, "1v1")
+	if !strings.HasPrefix(got, "could not send the ready check: ") || !strings.HasSuffix(got, ": alice,bob") {
+		t.Errorf("script got %q, want an unsent ready check with both players dropped", got)
+	}
+	checkQueued(t, m, map[string]bool{"alice": false, "bob": false})
+
+	m.do(func() {
+		if len(m.ready) != 0 {
+			t.Error("a ready check spinner was started for a ready check nobody got")
+		}
+	})
+}
@@ -0,0 +1,281 @@
+// Package matchmaking holds reusable matchmaking primitives: rating-window
+// pairing, team balancing, FFA bucketing and a match quality score. Queue
+// scripts decide policy; these do the combinatorics.
+package matchmaking
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// MaxExhaustive is the most distinct team assignments BalanceExhaustive will
+// try. the count explodes with the number of teams, not just players: per
+// BenchmarkBalanceExhaustive, 2 teams of 8 (6435 assignments) or 3 teams of 4
+// (5775) take around half a millisecond, while 4 teams of 4 (2.6 million) take
+// a quarter of a second, which is too long to hold up a queue.
+const MaxExhaustive = 10000
+
+// Candidate is a queued player as seen by the matchmaking primitives.
+type Candidate struct {
+	Name   string
+	Rating float64
+	Waited time.Duration
+}
+
+// Window describes how far apart two ratings may be before a pairing is
+// refused. it starts at Base and grows by Growth per second waited, capped at
+// Max (no cap if Max is zero).
+type Window struct {
+	Base   float64
+	Growth float64
+	Max    float64
+}
+
+// Width is the acceptable rating difference for a candidate who has waited
+// this long.
+func (w Window) Width(waited time.Duration) float64 {
+	width := w.Base + w.Growth*waited.Seconds()
+	if w.Max > 0 && width > w.Max {
+		return w.Max
+	}
+	return width
+}
+
+// PairByRating pairs candidates whose rating difference fits inside the wider
+// of their two windows. longest-waiting candidates pick first, and each picks
+// the closest rating available. unpaired candidates are returned in rest.
+func PairByRating(cands []Candidate, w Window) (pairs [][2]Candidate, rest []Candidate) {
+	order := make([]Candidate, len(cands))
+	copy(order, cands)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Waited > order[j].Waited
+	})
+
+	paired := make([]bool, len(order))
+	for i, a := range order {
+		if paired[i] {
+			continue
+		}
+
+		best := -1
+		bestDiff := math.Inf(1)
+		for j := i + 1; j < len(order); j++ {
+			if paired[j] {
+				continue
+			}
+			b := order[j]
+			diff := math.Abs(a.Rating - b.Rating)
+			if diff > math.Max(w.Width(a.Waited), w.Width(b.Waited)) {
+				continue
+			}
+			if diff < bestDiff {
+				best = j
+				bestDiff = diff
+			}
+		}
+
+		if best == -1 {
+			continue
+		}
+
+		paired[i] = true
+		paired[best] = true
+		pairs = append(pairs, [2]Candidate{a, order[best]})
+	}
+
+	for i, c := range order {
+		if !paired[i] {
+			rest = append(rest, c)
+		}
+	}
+
+	return pairs, rest
+}
+
+// BalanceGreedy splits candidates into equally sized teams by handing the
+// strongest remaining candidate to the weakest team with room left. it is
+// fast and usually close, but not optimal.
+func BalanceGreedy(cands []Candidate, teams int) ([][]Candidate, error) {
+	size, err := teamSize(len(cands), teams)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]Candidate, len(cands))
+	copy(order, cands)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Rating > order[j].Rating
+	})
+
+	result := make([][]Candidate, teams)
+	sums := make([]float64, teams)
+	for _, c := range order {
+		weakest := -1
+		for t := range result {
+			if len(result[t]) == size {
+				continue
+			}
+			if weakest == -1 || sums[t] < sums[weakest] {
+				weakest = t
+			}
+		}
+		result[weakest] = append(result[weakest], c)
+		sums[weakest] += c.Rating
+	}
+
+	return result, nil
+}
+
+// BalanceExhaustive splits candidates into equally sized teams, minimizing
+// the difference between the strongest and weakest team's rating total. it
+// tries every distinct assignment, so it refuses splits with more than
+// MaxExhaustive of them.
+func BalanceExhaustive(cands []Candidate, teams int) ([][]Candidate, error) {
+	size, err := teamSize(len(cands), teams)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := assignments(len(cands), teams, size); n > MaxExhaustive {
+		return nil, fmt.Errorf("matchmaking.BalanceExhaustive: %d candidates in %d teams is %.0f assignments, more than the limit of %d; use BalanceGreedy", len(cands), teams, n, MaxExhaustive)
+	}
+
+	assignment := make([]int, len(cands))
+	best := make([]int, len(cands))
+	bestSpread := math.Inf(1)
+	sums := make([]float64, teams)
+	counts := make([]int, teams)
+
+	var search func(i int)
+	search = func(i int) {
+		if i == len(cands) {
+			spread := spreadOf(sums)
+			if spread < bestSpread {
+				bestSpread = spread
+				copy(best, assignment)
+			}
+			return
+		}
+
+		for t := 0; t < teams; t++ {
+			if counts[t] == size {
+				continue
+			}
+
+			assignment[i] = t
+			counts[t]++
+			sums[t] += cands[i].Rating
+			search(i + 1)
+			counts[t]--
+			sums[t] -= cands[i].Rating
+
+			// teams are interchangeable: putting this candidate into any
+			// other empty team would only mirror the assignment we just tried
+			if counts[t] == 0 {
+				break
+			}
+		}
+	}
+	search(0)
+
+	result := make([][]Candidate, teams)
+	for i, t := range best {
+		result[t] = append(result[t], cands[i])
+	}
+
+	return result, nil
+}
+
+// BucketFFA sorts candidates by rating and groups neighbours into buckets of
+// the given size. candidates that don't fill a whole bucket are returned in
+// rest.
+func BucketFFA(cands []Candidate, size int) (buckets [][]Candidate, rest []Candidate) {
+	if size < 1 {
+		return nil, cands
+	}
+
+	order := make([]Candidate, len(cands))
+	copy(order, cands)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Rating > order[j].Rating
+	})
+
+	full := len(order) - len(order)%size
+	for i := 0; i < full; i += size {
+		buckets = append(buckets, order[i:i+size])
+	}
+
+	return buckets, order[full:]
+}
+
+// Quality scores a match from 0 (foregone conclusion) to 1 (coin flip). it
+// takes the Elo expected score between each pair of teams' average ratings
+// and reports the most lopsided pairing.
+func Quality(teams [][]Candidate) float64 {
+	if len(teams) < 2 {
+		return 0
+	}
+
+	avgs := make([]float64, len(teams))
+	for i, team := range teams {
+		if len(team) == 0 {
+			return 0
+		}
+		for _, c := range team {
+			avgs[i] += c.Rating
+		}
+		avgs[i] /= float64(len(team))
+	}
+
+	quality := 1.0
+	for i := range avgs {
+		for j := i + 1; j < len(avgs); j++ {
+			expected := 1 / (1 + math.Pow(10, (avgs[j]-avgs[i])/400))
+			quality = math.Min(quality, 1-2*math.Abs(expected-0.5))
+		}
+	}
+
+	return quality
+}
+
+// assignments counts the distinct ways to split players into teams of size,
+// with the teams interchangeable: the first unplaced player always starts the
+// next team, which then picks the rest of its members from whoever is left.
+func assignments(players, teams, size int) float64 {
+	count := 1.0
+	for left := players; left > size; left -= size {
+		count *= binomial(left-1, size-1)
+	}
+	return count
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return math.Round(result)
+}
+
+func teamSize(players, teams int) (int, error) {
+	if teams < 2 {
+		return 0, fmt.Errorf("matchmaking: need at least 2 teams, got %d", teams)
+	}
+
+	if players == 0 || players%teams != 0 {
+		return 0, fmt.Errorf("matchmaking: %d players can't be split evenly into %d teams", players, teams)
+	}
+
+	return players / teams, nil
+}
+
+func spreadOf(sums []float64) float64 {
+	min, max := sums[0], sums[0]
+	for _, s := range sums[1:] {
+		min = math.Min(min, s)
+		max = math.Max(max, s)
+	}
+	return max - min
+}
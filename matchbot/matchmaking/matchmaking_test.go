@@ -0,0 +1,316 @@
+package matchmaking
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func benchCandidates(n int) []Candidate {
+	rng := rand.New(rand.NewSource(1))
+	cands := make([]Candidate, n)
+	for i := range cands {
+		cands[i] = Candidate{
+			Name:   fmt.Sprintf("p%d", i),
+			Rating: 1500 + rng.NormFloat64()*300,
+		}
+	}
+	return cands
+}
+
+func BenchmarkBalanceExhaustive(b *testing.B) {
+	cases := []struct {
+		players int
+		teams   int
+	}{
+		{8, 2},
+		{10, 2},
+		{12, 2},
+		{12, 3},
+		{16, 2},
+	}
+
+	for _, c := range cases {
+		cands := benchCandidates(c.players)
+		b.Run(fmt.Sprintf("%dx%d", c.teams, c.players/c.teams), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := BalanceExhaustive(cands, c.teams)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	cases := []struct {
+		players int
+		teams   int
+		want    float64
+	}{
+		{2, 2, 1},
+		{4, 2, 3},
+		{8, 2, 35},
+		{16, 2, 6435},
+		{12, 3, 5775},
+		{16, 4, 2627625},
+	}
+
+	for _, c := range cases {
+		got := assignments(c.players, c.teams, c.players/c.teams)
+		if got != c.want {
+			t.Errorf("assignments(%d players, %d teams) = %.0f, want %.0f", c.players, c.teams, got, c.want)
+		}
+	}
+}
+
+func TestBalanceExhaustiveLimit(t *testing.T) {
+	_, err := BalanceExhaustive(benchCandidates(16), 2)
+	if err != nil {
+		t.Errorf("2 teams of 8 should be allowed: %v", err)
+	}
+
+	_, err = BalanceExhaustive(benchCandidates(16), 4)
+	if err == nil {
+		t.Errorf("4 teams of 4 should be refused")
+	}
+}
+
+func names(cands []Candidate) []string {
+	list := []string{}
+	for _, c := range cands {
+		list = append(list, c.Name)
+	}
+	return list
+}
+
+func teamNames(teams [][]Candidate) [][]string {
+	list := [][]string{}
+	for _, team := range teams {
+		list = append(list, names(team))
+	}
+	return list
+}
+
+func TestPairByRating(t *testing.T) {
+	window := Window{Base: 100, Growth: 10, Max: 300}
+	cases := []struct {
+		name  string
+		cands []Candidate
+		pairs [][]string
+		rest  []string
+	}{
+		{
+			name: "closest rating in the window",
+			cands: []Candidate{
+				{Name: "a", Rating: 1500, Waited: 30 * time.Second},
+				{Name: "b", Rating: 1560},
+				{Name: "c", Rating: 1520},
+			},
+			pairs: [][]string{{"a", "c"}},
+			rest:  []string{"b"},
+		},
+		{
+			name: "too far apart",
+			cands: []Candidate{
+				{Name: "a", Rating: 1500},
+				{Name: "b", Rating: 1700},
+			},
+			pairs: [][]string{},
+			rest:  []string{"a", "b"},
+		},
+		{
+			name: "waiting widens the window",
+			cands: []Candidate{
+				{Name: "a", Rating: 1500, Waited: 10 * time.Second},
+				{Name: "b", Rating: 1700},
+			},
+			pairs: [][]string{{"a", "b"}},
+			rest:  []string{},
+		},
+		{
+			name: "the wider window counts, and the longer wait picks first",
+			cands: []Candidate{
+				{Name: "a", Rating: 1500},
+				{Name: "b", Rating: 1650, Waited: 10 * time.Second},
+			},
+			pairs: [][]string{{"b", "a"}},
+			rest:  []string{},
+		},
+		{
+			name: "the window stops growing at max",
+			cands: []Candidate{
+				{Name: "a", Rating: 1500, Waited: time.Hour},
+				{Name: "b", Rating: 1900},
+			},
+			pairs: [][]string{},
+			rest:  []string{"a", "b"},
+		},
+	}
+
+	for _, c := range cases {
+		pairs, rest := PairByRating(c.cands, window)
+		got := [][]string{}
+		for _, pair := range pairs {
+			got = append(got, names(pair[:]))
+		}
+
+		if !reflect.DeepEqual(got, c.pairs) || !reflect.DeepEqual(names(rest), c.rest) {
+			t.Errorf("%v: got pairs %v rest %v, want pairs %v rest %v", c.name, got, names(rest), c.pairs, c.rest)
+		}
+	}
+}
+
+func rated(ratings ...float64) []Candidate {
+	cands := []Candidate{}
+	for i, rating := range ratings {
+		cands = append(cands, Candidate{Name: string(rune('a' + i)), Rating: rating})
+	}
+	return cands
+}
+
+func TestBalance(t *testing.T) {
+	cases := []struct {
+		name       string
+		balance    func([]Candidate, int) ([][]Candidate, error)
+		cands      []Candidate
+		teams      int
+		want       [][]string
+		wantFailed bool
+	}{
+		{
+			name:    "greedy 2v2",
+			balance: BalanceGreedy,
+			cands:   rated(2000, 1800, 1600, 1400),
+			teams:   2,
+			want:    [][]string{{"a", "d"}, {"b", "c"}},
+		},
+		{
+			name:    "greedy 2v2v2",
+			balance: BalanceGreedy,
+			cands:   rated(2000, 1900, 1800, 1700, 1600, 1500),
+			teams:   3,
+			want:    [][]string{{"a", "f"}, {"b", "e"}, {"c", "d"}},
+		},
+		{
+			// greedy gets 1000+600+200 against 800+700+500
+			name:    "greedy 3v3 misses the even split",
+			balance: BalanceGreedy,
+			cands:   rated(1000, 800, 700, 600, 500, 200),
+			teams:   2,
+			want:    [][]string{{"a", "d", "f"}, {"b", "c", "e"}},
+		},
+		{
+			name:    "exhaustive 3v3 finds it",
+			balance: BalanceExhaustive,
+			cands:   rated(1000, 800, 700, 600, 500, 200),
+			teams:   2,
+			want:    [][]string{{"a", "c", "f"}, {"b", "d", "e"}},
+		},
+		{
+			name:       "uneven",
+			balance:    BalanceGreedy,
+			cands:      rated(1500, 1500, 1500),
+			teams:      2,
+			wantFailed: true,
+		},
+		{
+			name:       "one team",
+			balance:    BalanceExhaustive,
+			cands:      rated(1500, 1500),
+			teams:      1,
+			wantFailed: true,
+		},
+	}
+
+	for _, c := range cases {
+		teams, err := c.balance(c.cands, c.teams)
+		if c.wantFailed {
+			if err == nil {
+				t.Errorf("%v: want an error, got %v", c.name, teamNames(teams))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		if got := teamNames(teams); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBucketFFA(t *testing.T) {
+	cases := []struct {
+		name    string
+		cands   []Candidate
+		size    int
+		buckets [][]string
+		rest    []string
+	}{
+		{
+			name:    "neighbours by rating, remainder left over",
+			cands:   rated(1500, 1900, 1100, 1700, 1300, 2100, 900),
+			size:    3,
+			buckets: [][]string{{"f", "b", "d"}, {"a", "e", "c"}},
+			rest:    []string{"g"},
+		},
+		{
+			name:    "exact fit",
+			cands:   rated(1500, 1600, 1700, 1800),
+			size:    2,
+			buckets: [][]string{{"d", "c"}, {"b", "a"}},
+			rest:    []string{},
+		},
+		{
+			name:    "not enough for a bucket",
+			cands:   rated(1500, 1600),
+			size:    3,
+			buckets: [][]string{},
+			rest:    []string{"b", "a"},
+		},
+		{
+			name:    "no size",
+			cands:   rated(1500, 1600),
+			size:    0,
+			buckets: [][]string{},
+			rest:    []string{"a", "b"},
+		},
+	}
+
+	for _, c := range cases {
+		buckets, rest := BucketFFA(c.cands, c.size)
+		if got := teamNames(buckets); !reflect.DeepEqual(got, c.buckets) || !reflect.DeepEqual(names(rest), c.rest) {
+			t.Errorf("%v: got buckets %v rest %v, want buckets %v rest %v", c.name, got, names(rest), c.buckets, c.rest)
+		}
+	}
+}
+
+func TestQuality(t *testing.T) {
+	cases := []struct {
+		name  string
+		teams [][]Candidate
+		want  float64
+	}{
+		{"even averages", [][]Candidate{rated(1400, 1600), rated(1500)}, 1},
+		// expected score 1/11 for the weaker side
+		{"400 points apart", [][]Candidate{rated(1500), rated(1900)}, 2.0 / 11},
+		{"the most lopsided pair counts", [][]Candidate{rated(1500), rated(1500), rated(1900)}, 2.0 / 11},
+		{"one team", [][]Candidate{rated(1500, 1500)}, 0},
+		{"empty team", [][]Candidate{rated(1500), {}}, 0},
+	}
+
+	for _, c := range cases {
+		got := Quality(c.teams)
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package queue
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/yuin/gopher-lua"
+	"time"
+)
+
+// how long to wait after a join or leave before calling queue.TryMatch, so a
+// burst of them (a team joining, a reconnect) is looked at once
+const tryMatchDelay = 250 * time.Millisecond
+
+// MatchResult is how a match went, as far as the matchbot can tell.
+type MatchResult struct {
+	Duration time.Duration
+	// empty if the game exited cleanly
+	Error string
+}
+
+// trigger asks for queue.TryMatch soon, rather than waiting for the next
+// Update. it runs on the queue's goroutine.
+func (q *Queue) trigger() {
+	q.triggered = true
+}
+
+// luaTryMatchCallin calls queue.TryMatch. scripts without one get an extra
+// queue.Update instead, if they have that.
+func (q *Queue) luaTryMatchCallin() {
+	q.triggered = false
+
+	_, err := q.getLuaCallin("TryMatch")
+	if err != nil {
+		q.luaUpdateCallin(q.elapsedSeconds())
+		return
+	}
+
+	q.callOptional("TryMatch")
+}
+
+// ReadyCheckFailed tells the script why a match fell through. dropped are
+// the players who leave the queue over it: those who declined or didn't
+// answer, or everyone if the game wouldn't start, so nobody is matched into
+// the same failure again. the script sees them as match.unready, and then
+// gets a queue.PlayerLeft for each. the rest go back to waiting.
+func (q *Queue) ReadyCheckFailed(match *Match, reason string, dropped []string) error {
+	return q.do(func() error {
+		// described before the players lose their seats
+		info := q.luaMatch(match)
+		names := q.L.NewTable()
+		drop := map[string]bool{}
+		for _, name := range dropped {
+			names.Append(lua.LString(name))
+			drop[name] = true
+		}
+		info.RawSetString("unready", names)
+
+		leaving := []string{}
+		for _, p := range match.Players {
+			player, ok := q.players[p.Name]
+			if !ok || player != p || player.Status() != Matched {
+				continue
+			}
+
+			if drop[p.Name] {
+				leaving = append(leaving, p.Name)
+			} else {
+				player.SetWaiting()
+			}
+		}
+
+		q.callOptional("ReadyCheckFailed", info, lua.LString(reason))
+
+		for _, name := range leaving {
+			err := q.removePlayer(name)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event":    "queue.ReadyCheckFailed",
+					"queue":    q.name,
+					"match_id": match.Id,
+					"user":     name,
+					"error":    err,
+				}).Warn("could not remove player after their ready check")
+			}
+		}
+
+		q.trigger()
+		return nil
+	})
+}
+
+// MatchStarted tells the script a match's game is up and running. only now
+// does it count towards the players' recent maps and the match history:
+// matches which fall through at the ready check were never played.
+func (q *Queue) MatchStarted(match *Match) error {
+	return q.do(func() error {
+		for _, p := range match.Players {
+			p.SetPlaying()
+		}
+
+		q.recordMap(match.Players, match.Map)
+		q.recordHistory(match)
+		q.saveState(recentMapsKey, q.recentMaps)
+		q.saveState(historyKey, q.history)
+
+		q.callOptional("MatchStarted", q.luaMatch(match))
+		return nil
+	})
+}
+
+// MatchEnded tells the script how a match went. its players are done with
+// the queue: they leave it, and may join again.
+func (q *Queue) MatchEnded(match *Match, result MatchResult) error {
+	return q.do(func() error {
+		for _, p := range match.Players {
+			player, ok := q.players[p.Name]
+			if !ok || player != p {
+				continue
+			}
+
+			err := q.removePlayer(p.Name)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event":    "queue.MatchEnded",
+					"queue":    q.name,
+					"match_id": match.Id,
+					"user":     p.Name,
+					"error":    err,
+				}).Warn("could not remove player after their match")
+			}
+		}
+
+		info := q.L.NewTable()
+		info.RawSetString("duration", lua.LNumber(result.Duration.Seconds()))
+		if result.Error != "" {
+			info.RawSetString("error", lua.LString(result.Error))
+		}
+
+		q.callOptional("MatchEnded", q.luaMatch(match), info)
+		return nil
+	})
+}
+
+// luaMatch describes a match to the script, much like NewMatch was given it.
+func (q *Queue) luaMatch(match *Match) *lua.LTable {
+	info := q.L.NewTable()
+	info.RawSetString("id", lua.LNumber(match.Id))
+	info.RawSetString("map", lua.LString(match.Map))
+	info.RawSetString("mapReason", lua.LString(match.MapReason))
+	info.RawSetString("game", lua.LString(match.Game))
+	info.RawSetString("gameReason", lua.LString(match.GameReason))
+	info.RawSetString("engineVersion", lua.LString(match.EngineVersion))
+
+	players := q.L.NewTable()
+	for _, p := range match.Players {
+		player := q.L.NewTable()
+		player.RawSetString("name", lua.LString(p.Name))
+		if p.Game != nil {
+			player.RawSetString("team", lua.LNumber(p.Game.Team))
+			player.RawSetString("ally", lua.LNumber(p.Game.AllyTeam))
+			player.RawSetString("spectator", lua.LBool(p.Game.Spectator))
+		}
+		players.Append(player)
+	}
+	info.RawSetString("players", players)
+	return info
+}
+
+// callOptional calls a callin the script may or may not define. errors are
+// the script's problem, so they're only logged.
+func (q *Queue) callOptional(name string, args ...lua.LValue) {
+	callin, err := q.getLuaCallin(name)
+	if err != nil {
+		return
+	}
+
+	err = q.L.CallByParam(lua.P{
+		Fn:      callin,
+		NRet:    0,
+		Protect: true,
+	}, args...)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": "queue.callOptional",
+			"queue": q.name,
+			"error": err,
+		}).Warnf("error calling '%v'", name)
+	}
+}
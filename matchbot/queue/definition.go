@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"time"
+)
+
+// Definition is a queue as this bot configures it: the lobby-visible
+// protocol.QueueDefinition, plus matchmaking settings the server never sees.
+// both live side by side in the same JSON object in the queues file.
+type Definition struct {
+	protocol.QueueDefinition
+
+	// MapWeights biases map selection; maps not listed here get weight 1,
+	// and a weight of 0 takes a map out of rotation.
+	MapWeights map[string]int `json:"mapWeights,omitempty"`
+	// GameWeights does the same for game selection
+	GameWeights map[string]int `json:"gameWeights,omitempty"`
+
+	// startscript defaults for every match in this queue
+	Script ScriptOptions `json:"script"`
+
+	// the queue's matchmaking script; DefaultLuaFile if empty
+	LuaFile string `json:"luaFile,omitempty"`
+
+	// how often queue.Update is called, as a Go duration like "500ms" or
+	// "5s"; DefaultUpdateInterval if empty
+	UpdateInterval string `json:"updateInterval,omitempty"`
+}
+
+// DefaultUpdateInterval is how often queues call queue.Update, unless their
+// definition says otherwise.
+const DefaultUpdateInterval = time.Second
+
+// DefaultLuaFile runs queues which don't name a script of their own.
+const DefaultLuaFile = "example/lua/bozo_1v1.lua"
+
+// Interval is how often this queue calls queue.Update.
+func (d *Definition) Interval() (time.Duration, error) {
+	if d.UpdateInterval == "" {
+		return DefaultUpdateInterval, nil
+	}
+
+	interval, err := time.ParseDuration(d.UpdateInterval)
+	if err != nil {
+		return 0, fmt.Errorf("bad updateInterval %q: %v", d.UpdateInterval, err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("updateInterval %q must be positive", d.UpdateInterval)
+	}
+	return interval, nil
+}
+
+// ScriptFile is the Lua file this queue runs.
+func (d *Definition) ScriptFile() string {
+	if d.LuaFile == "" {
+		return DefaultLuaFile
+	}
+	return d.LuaFile
+}
+
+// StartBox is an allyteam's start area, each edge a fraction (0 to 1) of the
+// map's size.
+type StartBox struct {
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Right  float64 `json:"right"`
+	Bottom float64 `json:"bottom"`
+}
+
+// ScriptOptions are the startscript settings a queue can tune. a Definition
+// holds the queue's defaults, which each match from Lua may override.
+type ScriptOptions struct {
+	// see spring's StartPosType: 0 fixed, 1 random, 2 chosen in game
+	StartPosType *int              `json:"startPosType,omitempty"`
+	ModOptions   map[string]string `json:"modOptions,omitempty"`
+	MapOptions   map[string]string `json:"mapOptions,omitempty"`
+	// unit name to the maximum number each team may build
+	Restrictions map[string]int `json:"restrictions,omitempty"`
+	// keyed by allyteam
+	StartBoxes map[int]StartBox `json:"startBoxes,omitempty"`
+}
+
+// Merge layers overrides on top of o: scalar settings are replaced, and map
+// settings are replaced key by key.
+func (o ScriptOptions) Merge(overrides ScriptOptions) ScriptOptions {
+	merged := ScriptOptions{
+		StartPosType: o.StartPosType,
+		ModOptions:   mergeStrings(o.ModOptions, overrides.ModOptions),
+		MapOptions:   mergeStrings(o.MapOptions, overrides.MapOptions),
+		Restrictions: map[string]int{},
+		StartBoxes:   map[int]StartBox{},
+	}
+
+	if overrides.StartPosType != nil {
+		merged.StartPosType = overrides.StartPosType
+	}
+
+	for unit, limit := range o.Restrictions {
+		merged.Restrictions[unit] = limit
+	}
+	for unit, limit := range overrides.Restrictions {
+		merged.Restrictions[unit] = limit
+	}
+
+	for allyTeam, box := range o.StartBoxes {
+		merged.StartBoxes[allyTeam] = box
+	}
+	for allyTeam, box := range overrides.StartBoxes {
+		merged.StartBoxes[allyTeam] = box
+	}
+
+	return merged
+}
+
+func mergeStrings(base, overrides map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"github.com/yuin/gopher-lua"
+	"time"
+)
+
+// how many started matches each queue remembers
+const historyKept = 50
+
+// HistoryEntry is a match the queue made, and why it was set up as it was.
+type HistoryEntry struct {
+	Id         uint64    `json:"id"`
+	Map        string    `json:"map"`
+	MapReason  string    `json:"mapReason"`
+	Game       string    `json:"game"`
+	GameReason string    `json:"gameReason"`
+	Players    []string  `json:"players"`
+	Started    time.Time `json:"started"`
+}
+
+// recordHistory adds a started match to the history. it runs on the queue's
+// goroutine.
+func (q *Queue) recordHistory(match *Match) {
+	entry := HistoryEntry{
+		Id:         match.Id,
+		Map:        match.Map,
+		MapReason:  match.MapReason,
+		Game:       match.Game,
+		GameReason: match.GameReason,
+		Started:    q.clock.Now(),
+	}
+
+	for _, p := range match.Players {
+		entry.Players = append(entry.Players, p.Name)
+	}
+
+	q.history = append(q.history, entry)
+	if len(q.history) > historyKept {
+		q.history = q.history[len(q.history)-historyKept:]
+	}
+}
+
+// luaHistory is the match history for queue.GetMatchHistory, newest first.
+func (q *Queue) luaHistory() *lua.LTable {
+	tab := q.L.NewTable()
+	for i := len(q.history) - 1; i >= 0; i-- {
+		entry := q.history[i]
+		info := q.L.NewTable()
+		info.RawSetString("id", lua.LNumber(entry.Id))
+		info.RawSetString("map", lua.LString(entry.Map))
+		info.RawSetString("mapReason", lua.LString(entry.MapReason))
+		info.RawSetString("game", lua.LString(entry.Game))
+		info.RawSetString("gameReason", lua.LString(entry.GameReason))
+		info.RawSetString("started", lua.LNumber(entry.Started.Unix()))
+
+		players := q.L.NewTable()
+		for _, name := range entry.Players {
+			players.Append(lua.LString(name))
+		}
+		info.RawSetString("players", players)
+		tab.Append(info)
+	}
+	return tab
+}
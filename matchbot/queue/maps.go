@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// how many of a player's most recent maps are avoided when picking the next one
+const recentMapsKept = 2
+
+// pickMap chooses a map from the queue's pool for the given players, and
+// explains why. bans and recently played maps are avoided unless that would
+// leave nothing to play; preferences make a map more likely, but never
+// guaranteed. it runs on the queue's goroutine.
+func (q *Queue) pickMap(players []*Player) (string, string, error) {
+	pool := []string{}
+	for _, mapName := range q.Def.MapNames {
+		weight, ok := q.Def.MapWeights[mapName]
+		if !ok || weight > 0 {
+			pool = append(pool, mapName)
+		}
+	}
+
+	if len(pool) == 0 {
+		return "", "", fmt.Errorf("queue.pickMap: queue %v has no maps in rotation", q.Def.Name)
+	}
+
+	banned := map[string]bool{}
+	recent := map[string]bool{}
+	votes := map[string]int{}
+	for _, p := range players {
+		for _, mapName := range p.MapVote.Banned {
+			banned[mapName] = true
+		}
+		for _, mapName := range p.MapVote.Preferred {
+			votes[mapName]++
+		}
+		for _, mapName := range q.recentMaps[p.Name] {
+			recent[mapName] = true
+		}
+	}
+
+	unbanned := without(pool, banned)
+	bansIgnored := len(unbanned) == 0
+	if !bansIgnored {
+		pool = unbanned
+	}
+
+	fresh := without(pool, recent)
+	recentIgnored := len(fresh) == 0
+	if !recentIgnored {
+		pool = fresh
+	}
+
+	weights := make([]int, len(pool))
+	for i, mapName := range pool {
+		weight, ok := q.Def.MapWeights[mapName]
+		if !ok {
+			weight = 1
+		}
+		weights[i] = weight * (1 + votes[mapName])
+	}
+
+	choice := weightedPick(q.rand, pool, weights)
+
+	reason := fmt.Sprintf("weighted pick from %d of %d maps, %d votes for it", len(pool), len(q.Def.MapNames), votes[choice])
+	if bansIgnored {
+		reason += ", bans ignored: every map was banned"
+	}
+	if recentIgnored {
+		reason += ", recent maps ignored: every map was played recently"
+	}
+
+	return choice, reason, nil
+}
+
+// pickGame chooses a game from the queue's list, by weight. players have no
+// say: the lobby doesn't send game preferences. it runs on the queue's
+// goroutine.
+func (q *Queue) pickGame() (string, string, error) {
+	pool := []string{}
+	weights := []int{}
+	for _, gameName := range q.Def.GameNames {
+		weight, ok := q.Def.GameWeights[gameName]
+		if !ok {
+			weight = 1
+		}
+		if weight > 0 {
+			pool = append(pool, gameName)
+			weights = append(weights, weight)
+		}
+	}
+
+	if len(pool) == 0 {
+		return "", "", fmt.Errorf("queue.pickGame: queue %v has no games in rotation", q.Def.Name)
+	}
+
+	choice := weightedPick(q.rand, pool, weights)
+	reason := fmt.Sprintf("weighted pick from %d of %d games", len(pool), len(q.Def.GameNames))
+	return choice, reason, nil
+}
+
+// weightedPick chooses from pool at random, each item as likely as its
+// weight. the weights must add up to more than zero.
+func weightedPick(rng *rand.Rand, pool []string, weights []int) string {
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	roll := rng.Intn(total)
+	for i, weight := range weights {
+		if roll < weight {
+			return pool[i]
+		}
+		roll -= weight
+	}
+	return pool[len(pool)-1]
+}
+
+// recordMap remembers that these players were matched on mapName, so the next
+// pickMap can steer them elsewhere. it runs on the queue's goroutine.
+func (q *Queue) recordMap(players []*Player, mapName string) {
+	for _, p := range players {
+		recent := append(q.recentMaps[p.Name], mapName)
+		if len(recent) > recentMapsKept {
+			recent = recent[len(recent)-recentMapsKept:]
+		}
+		q.recentMaps[p.Name] = recent
+	}
+}
+
+func without(list []string, drop map[string]bool) []string {
+	kept := []string{}
+	for _, item := range list {
+		if !drop[item] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
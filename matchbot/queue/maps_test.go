@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// picks per case: enough for the shares to settle within a few percent
+const picks = 2000
+
+func voter(name string, preferred []string, banned []string) *Player {
+	p := NewPlayer(name)
+	p.MapVote = MapVote{Preferred: preferred, Banned: banned}
+	return p
+}
+
+func pickingQueue(maps []string, mapWeights map[string]int, games []string, gameWeights map[string]int) *Queue {
+	def := &Definition{MapWeights: mapWeights, GameWeights: gameWeights}
+	def.Name = "1v1"
+	def.MapNames = maps
+	def.GameNames = games
+	return &Queue{
+		Def:        def,
+		rand:       rand.New(rand.NewSource(1)),
+		recentMaps: map[string][]string{},
+	}
+}
+
+// checkShares fails unless each choice came up about as often as want says;
+// choices missing from want must never come up.
+func checkShares(t *testing.T, name string, counts map[string]int, want map[string]float64) {
+	for choice, count := range counts {
+		if _, ok := want[choice]; !ok {
+			t.Errorf("%v: %v was picked %d times, want never", name, choice, count)
+		}
+	}
+
+	for choice, share := range want {
+		got := float64(counts[choice]) / picks
+		if math.Abs(got-share) > 0.04 {
+			t.Errorf("%v: %v picked %.2f of the time, want %.2f", name, choice, got, share)
+		}
+	}
+}
+
+func TestPickMap(t *testing.T) {
+	maps := []string{"Altair", "Comet", "Delta"}
+	cases := []struct {
+		name    string
+		weights map[string]int
+		players []*Player
+		recent  map[string][]string
+		want    map[string]float64
+		reason  string
+		failed  bool
+	}{
+		{
+			name:    "unweighted",
+			players: []*Player{voter("alice", nil, nil)},
+			want:    map[string]float64{"Altair": 1.0 / 3, "Comet": 1.0 / 3, "Delta": 1.0 / 3},
+			reason:  "weighted pick from 3 of 3 maps",
+		},
+		{
+			name:    "weights, and zero takes a map out of rotation",
+			weights: map[string]int{"Altair": 3, "Delta": 0},
+			players: []*Player{voter("alice", nil, nil)},
+			want:    map[string]float64{"Altair": 0.75, "Comet": 0.25},
+			reason:  "weighted pick from 2 of 3 maps",
+		},
+		{
+			name:    "bans",
+			players: []*Player{voter("alice", nil, []string{"Altair"}), voter("bob", nil, []string{"Comet"})},
+			want:    map[string]float64{"Delta": 1},
+		},
+		{
+			name:    "everything banned",
+			players: []*Player{voter("alice", nil, maps)},
+			want:    map[string]float64{"Altair": 1.0 / 3, "Comet": 1.0 / 3, "Delta": 1.0 / 3},
+			reason:  "bans ignored",
+		},
+		{
+			name:    "recent maps",
+			players: []*Player{voter("alice", nil, nil), voter("bob", nil, nil)},
+			recent:  map[string][]string{"alice": {"Altair"}, "bob": {"Delta"}},
+			want:    map[string]float64{"Comet": 1},
+		},
+		{
+			name:    "everything recent",
+			players: []*Player{voter("alice", nil, nil)},
+			recent:  map[string][]string{"alice": {"Altair", "Comet"}},
+			weights: map[string]int{"Delta": 0},
+			want:    map[string]float64{"Altair": 0.5, "Comet": 0.5},
+			reason:  "recent maps ignored",
+		},
+		{
+			name:    "bans beat recent maps",
+			players: []*Player{voter("alice", nil, []string{"Altair", "Comet"})},
+			recent:  map[string][]string{"alice": {"Delta"}},
+			want:    map[string]float64{"Delta": 1},
+			reason:  "recent maps ignored",
+		},
+		{
+			// each vote adds the map's weight once more
+			name:    "votes",
+			players: []*Player{voter("alice", []string{"Comet"}, nil), voter("bob", []string{"Comet"}, nil)},
+			want:    map[string]float64{"Altair": 0.2, "Comet": 0.6, "Delta": 0.2},
+		},
+		{
+			name:    "nothing in rotation",
+			weights: map[string]int{"Altair": 0, "Comet": 0, "Delta": 0},
+			players: []*Player{voter("alice", nil, nil)},
+			failed:  true,
+		},
+	}
+
+	for _, c := range cases {
+		q := pickingQueue(maps, c.weights, nil, nil)
+		if c.recent != nil {
+			q.recentMaps = c.recent
+		}
+
+		counts := map[string]int{}
+		for i := 0; i < picks; i++ {
+			choice, reason, err := q.pickMap(c.players)
+			if c.failed {
+				if err == nil {
+					t.Errorf("%v: want an error, got %v", c.name, choice)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: %v", c.name, err)
+			}
+
+			if !strings.Contains(reason, c.reason) {
+				t.Errorf("%v: reason %q does not mention %q", c.name, reason, c.reason)
+				break
+			}
+			counts[choice]++
+		}
+
+		if !c.failed {
+			checkShares(t, c.name, counts, c.want)
+		}
+	}
+}
+
+func TestRecordMap(t *testing.T) {
+	q := pickingQueue(nil, nil, nil, nil)
+	alice := voter("alice", nil, nil)
+	for _, mapName := range []string{"Altair", "Comet", "Delta"} {
+		q.recordMap([]*Player{alice}, mapName)
+	}
+
+	want := []string{"Comet", "Delta"}
+	if recent := q.recentMaps["alice"]; !reflect.DeepEqual(recent, want) {
+		t.Errorf("recent maps are %v, want the last %d: %v", recent, recentMapsKept, want)
+	}
+}
+
+func TestPickGame(t *testing.T) {
+	games := []string{"BA", "S44"}
+	cases := []struct {
+		name    string
+		weights map[string]int
+		want    map[string]float64
+		failed  bool
+	}{
+		{"unweighted", nil, map[string]float64{"BA": 0.5, "S44": 0.5}, false},
+		{"weighted", map[string]int{"BA": 4}, map[string]float64{"BA": 0.8, "S44": 0.2}, false},
+		{"out of rotation", map[string]int{"S44": 0}, map[string]float64{"BA": 1}, false},
+		{"nothing in rotation", map[string]int{"BA": 0, "S44": 0}, nil, true},
+	}
+
+	for _, c := range cases {
+		q := pickingQueue(nil, nil, games, c.weights)
+		counts := map[string]int{}
+		for i := 0; i < picks; i++ {
+			choice, _, err := q.pickGame()
+			if c.failed {
+				if err == nil {
+					t.Errorf("%v: want an error, got %v", c.name, choice)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: %v", c.name, err)
+			}
+			counts[choice]++
+		}
+
+		if !c.failed {
+			checkShares(t, c.name, counts, c.want)
+		}
+	}
+}
+
+// the same seed makes the same picks
+func TestWeightedPickSeeded(t *testing.T) {
+	pool := []string{"Altair", "Comet", "Delta"}
+	weights := []int{1, 2, 3}
+
+	a := rand.New(rand.NewSource(7))
+	b := rand.New(rand.NewSource(7))
+	for i := 0; i < 100; i++ {
+		if x, y := weightedPick(a, pool, weights), weightedPick(b, pool, weights); x != y {
+			t.Fatalf("pick %d differs between runs with the same seed: %v and %v", i, x, y)
+		}
+	}
+}
@@ -5,6 +5,9 @@ type Match struct {
 	QueueName     string
 	Game          string
 	Map           string
+	MapReason     string
+	GameReason    string
 	EngineVersion string
 	Players       []*Player
+	Script        ScriptOptions
 }
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+)
+
+// the queue's own state lives in the store next to the script's, under a
+// namespace of its own so neither can trample the other's keys
+const (
+	matchIdKey    = "matchId"
+	recentMapsKey = "recentMaps"
+	historyKey    = "history"
+)
+
+// ReservedNameChars can't be used in queue names. scripts keep their state
+// under their queue's name, so a queue's own state is kept under a namespace
+// with one of these in it: no queue's script can ever share it.
+const ReservedNameChars = ":"
+
+func stateNamespace(queueName string) string {
+	return "queue:" + queueName
+}
+
+func (q *Queue) stateNamespace() string {
+	return stateNamespace(q.name)
+}
+
+// loadState picks up where the last run of this queue left off: match ids
+// carry on from the last one handed out, and the recent maps and history are
+// as they were. anything missing or unreadable starts afresh.
+func (q *Queue) loadState() {
+	load(q, matchIdKey, &q.matchId)
+	load(q, recentMapsKey, &q.recentMaps)
+	load(q, historyKey, &q.history)
+}
+
+// load replaces *value with what's stored under key, if that can be read.
+func load[T any](q *Queue, key string, value *T) {
+	raw, ok, err := q.store.Get(q.stateNamespace(), key)
+	if err != nil || !ok {
+		if err != nil {
+			q.logState(key, err).Warn("could not read queue state, starting afresh")
+		}
+		return
+	}
+
+	var stored T
+	err = json.Unmarshal([]byte(raw), &stored)
+	if err != nil {
+		q.logState(key, err).Warn("stored queue state is not valid, starting afresh")
+		return
+	}
+	*value = stored
+}
+
+// saveState stores value under key. a failure costs the queue its memory
+// across a restart, not its matches, so it's only logged.
+func (q *Queue) saveState(key string, value interface{}) {
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = q.store.Set(q.stateNamespace(), key, string(b))
+	}
+
+	if err != nil {
+		q.logState(key, err).Warn("could not store queue state")
+	}
+}
+
+func (q *Queue) logState(key string, err error) *log.Entry {
+	return log.WithFields(log.Fields{
+		"event": "queue.state",
+		"queue": q.name,
+		"key":   key,
+		"error": err,
+	})
+}
@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"context"
+	"github.com/kanatohodets/go-match/matchbot/clock"
+	"github.com/kanatohodets/go-match/matchbot/queue/queuetest"
+	"github.com/kanatohodets/go-match/matchbot/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func pairDefinition(t *testing.T) *Definition {
+	return &Definition{
+		QueueDefinition: queuetest.Queue("1v1"),
+		LuaFile:         queuetest.Script(t, queuetest.PairScript),
+	}
+}
+
+// openQueue starts a manual queue on st, which matches as soon as players
+// join.
+func openQueue(t *testing.T, def *Definition, st store.Store) (*Queue, chan *Match) {
+	matches := make(chan *Match, 1)
+	q, err := NewQueue(def, matches, Options{
+		Clock:  clock.NewFake(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		Manual: true,
+		Store:  st,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, matches
+}
+
+func playMatch(t *testing.T, q *Queue, matches chan *Match, players ...string) *Match {
+	for _, name := range players {
+		err := q.AddPlayer(name, MapVote{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var match *Match
+	select {
+	case match = <-matches:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no match was made")
+	}
+
+	err := q.MatchStarted(match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return match
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	def := pairDefinition(t)
+	st := store.NewMemory()
+
+	q, matches := openQueue(t, def, st)
+	first := playMatch(t, q, matches, "alice", "bob")
+	q.Close()
+
+	q, matches = openQueue(t, def, st)
+	defer q.Close()
+
+	err := q.do(func() error {
+		if want := []string{"DeltaSiegeDry"}; !reflect.DeepEqual(q.recentMaps["alice"], want) {
+			t.Errorf("alice's recent maps are %v, want %v", q.recentMaps["alice"], want)
+		}
+		if len(q.history) != 1 || q.history[0].Id != first.Id || !reflect.DeepEqual(q.history[0].Players, []string{"alice", "bob"}) {
+			t.Errorf("history is %+v, want the first match", q.history)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := playMatch(t, q, matches, "carol", "dave")
+	if second.Id != first.Id+1 {
+		t.Errorf("match id after a restart is %v, want %v", second.Id, first.Id+1)
+	}
+
+	// the script's namespace is the script's alone
+	keys, err := st.Keys(def.Name)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("queue state leaked into the script's namespace: %v %v", keys, err)
+	}
+}
+
+func TestStateUnreadable(t *testing.T) {
+	def := pairDefinition(t)
+	st := store.NewMemory()
+	st.Set(stateNamespace(def.Name), matchIdKey, "not a number")
+	st.Set(stateNamespace(def.Name), recentMapsKey, "null")
+	st.Set(stateNamespace(def.Name), historyKey, `[{"id": "one"}]`)
+
+	q, matches := openQueue(t, def, st)
+	defer q.Close()
+
+	match := playMatch(t, q, matches, "alice", "bob")
+	if match.Id != 1 {
+		t.Errorf("match id from unreadable state is %v, want 1", match.Id)
+	}
+
+	err := q.do(func() error {
+		if len(q.history) != 1 {
+			t.Errorf("history is %+v, want only the new match", q.history)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a script's namespace is its queue's name, which can never be the
+// namespace of any queue's own state
+func TestStateNamespace(t *testing.T) {
+	st := store.NewMemory()
+	q, matches := openQueue(t, pairDefinition(t), st)
+	defer q.Close()
+	playMatch(t, q, matches, "alice", "bob")
+
+	keys, err := st.Keys(stateNamespace("1v1"))
+	if err != nil || len(keys) == 0 {
+		t.Fatalf("1v1 stored no state: %v %v", keys, err)
+	}
+
+	for _, name := range []string{"queue/1v1", "queue:1v1", stateNamespace("1v1")} {
+		def := pairDefinition(t)
+		def.Name = name
+		other, err := NewQueue(def, nil, Options{Store: st})
+		if err != nil {
+			continue
+		}
+		other.Close()
+
+		// a queue named like that would be reading and writing 1v1's state
+		if keys, _ := st.Keys(name); len(keys) > 0 {
+			t.Errorf("a queue named %q can use 1v1's state, %v", name, keys)
+		}
+	}
+}
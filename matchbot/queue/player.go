@@ -10,19 +10,35 @@ const (
 	Playing
 )
 
-type ingame struct {
+// Seat is where a matched player sits in the game.
+type Seat struct {
 	Team     int
 	AllyTeam int
+	// optional per-team settings; the first player seated on a team sets them
+	Side     string
+	Color    []float64
+	Handicap int
+
+	// spectators have no team or allyteam
+	Spectator bool
+}
+
+// MapVote is what a player asked for when joining: maps to prefer, and maps
+// they never want to play.
+type MapVote struct {
+	Preferred []string
+	Banned    []string
 }
 
 type Player struct {
 	Name      string
 	QueueTeam string
+	MapVote   MapVote
 
 	status PlayerStatus
 	mut    sync.RWMutex
 
-	Game *ingame
+	Game *Seat
 }
 
 func NewPlayer(name string) *Player {
@@ -45,13 +61,10 @@ func (p *Player) SetWaiting() {
 	p.status = Waiting
 }
 
-func (p *Player) SetMatched(team, allyTeam int) {
+func (p *Player) SetMatched(seat *Seat) {
 	p.mut.Lock()
 	defer p.mut.Unlock()
-	p.Game = &ingame{
-		Team:     team,
-		AllyTeam: allyTeam,
-	}
+	p.Game = seat
 	p.status = Matched
 }
 
@@ -1,54 +1,176 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/clock"
+	"github.com/kanatohodets/go-match/matchbot/store"
 	"github.com/kanatohodets/go-match/spring/lobby/protocol"
 	"github.com/yuin/gopher-lua"
+	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type Queue struct {
-	L    *lua.LState
-	LMut sync.Mutex
-
-	playersMut sync.Mutex
-	players    map[string]*Player
+var (
+	// ErrClosed is returned when using a queue which has been closed.
+	ErrClosed = errors.New("queue is closed")
+	// ErrStopped is returned when using a queue which isn't running: not
+	// started yet, or stopped.
+	ErrStopped = errors.New("queue is not running")
+)
 
-	Def     *protocol.QueueDefinition
+// Queue runs a single queue's Lua script. the Lua state and the players
+// belong to the queue's own goroutine (see run): other goroutines hand it
+// work through do, and never touch them directly.
+type Queue struct {
+	L *lua.LState
+
+	players map[string]*Player
+	// maps each player was most recently matched on, kept across rejoins
+	recentMaps map[string][]string
+	// matches which got as far as starting, oldest first
+	history []HistoryEntry
+
+	// owned by the queue's goroutine, as it may be replaced by Update. the
+	// name never changes; use Name from other goroutines.
+	Def     *Definition
+	name    string
+	users   UserLookup
+	ratings RatingLookup
+	store   store.Store
 	Matches chan<- *Match
 
+	clock   clock.Clock
+	started time.Time
+	manual  bool
+	// for map and game picks; owned by the queue's goroutine
+	rand *rand.Rand
+	// how often to call queue.Update; owned by the queue's goroutine
+	interval time.Duration
+	// the script has an Update callin; without one there is nothing to tick
+	updates bool
+	// something happened which the script may want to match on, see trigger
+	triggered bool
+	// fires when it's time to act on triggered; nil if not waiting
+	debounce clock.Timer
+
 	matchId uint64
+
+	actions chan func()
+
+	// the running goroutine, if any: cancel stops it, and done is closed
+	// once it has stopped
+	lifecycleMut sync.Mutex
+	cancel       context.CancelFunc
+	done         chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// UserLookup finds a player's lobby details, if they are online.
+type UserLookup func(name string) (protocol.User, bool)
+
+// RatingLookup finds a player's skill rating, if there is one.
+type RatingLookup func(name string) (float64, bool)
+
+// Options are the optional parts of a queue; the zero value is fine.
+type Options struct {
+	Users   UserLookup
+	Ratings RatingLookup
+
+	// the queue's idea of the time, as seen by queue.Update and os.time() in
+	// Lua; clock.Real if nil
+	Clock clock.Clock
+	// don't call queue.Update on a timer: the owner calls Tick instead
+	Manual bool
+	// where queue.Store keeps the script's state, under the queue's name;
+	// a fresh store.Memory if nil
+	Store store.Store
+	// seeds the map and game picks, for repeatable runs; 0 for a different
+	// seed every time
+	Seed int64
 }
 
-func NewQueue(def *protocol.QueueDefinition, matches chan<- *Match) (*Queue, error) {
+func NewQueue(def *Definition, matches chan<- *Match, opts Options) (*Queue, error) {
+	users := opts.Users
+	if users == nil {
+		users = func(string) (protocol.User, bool) { return protocol.User{}, false }
+	}
+
+	ratings := opts.Ratings
+	if ratings == nil {
+		ratings = func(string) (float64, bool) { return 0, false }
+	}
+
+	queueClock := opts.Clock
+	if queueClock == nil {
+		queueClock = clock.Real
+	}
+
+	queueStore := opts.Store
+	if queueStore == nil {
+		queueStore = store.NewMemory()
+	}
+
+	if strings.ContainsAny(def.Name, ReservedNameChars) {
+		return nil, fmt.Errorf("queue %v: names can't contain any of %q", def.Name, ReservedNameChars)
+	}
+
+	interval, err := def.Interval()
+	if err != nil {
+		return nil, fmt.Errorf("queue %v: %v", def.Name, err)
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
 	q := &Queue{
-		L:       lua.NewState(),
-		Def:     def,
-		players: make(map[string]*Player),
-		Matches: matches,
+		L:          lua.NewState(),
+		Def:        def,
+		name:       def.Name,
+		users:      users,
+		ratings:    ratings,
+		store:      queueStore,
+		clock:      queueClock,
+		rand:       rand.New(rand.NewSource(seed)),
+		started:    queueClock.Now(),
+		manual:     opts.Manual,
+		interval:   interval,
+		players:    make(map[string]*Player),
+		recentMaps: make(map[string][]string),
+		Matches:    matches,
+		actions:    make(chan func()),
+		closed:     make(chan struct{}),
+	}
 
-		matchId: 0, // yes, it defaults to zero, but TODO: read from KV store
+	q.loadState()
+	if q.recentMaps == nil {
+		q.recentMaps = make(map[string][]string)
 	}
 
 	q.populateAPI()
-	// TODO: populate this from config/KV store
-	file := "example/lua/bozo_1v1.lua"
-	err := q.L.DoFile(file)
+	file := def.ScriptFile()
+	err = q.L.DoFile(file)
 	if err != nil {
+		q.L.Close()
 		return nil, fmt.Errorf("could not load %v: %v", file, err)
 	}
 
-	go q.luaUpdateCallin()
+	_, err = q.getLuaCallin("Update")
+	q.updates = err == nil
+
 	return q, nil
 }
 
 func (q *Queue) populateAPI() {
-	q.LMut.Lock()
-	defer q.LMut.Unlock()
-
 	queueNamespace := q.L.NewTable()
 	// TODO: perhaps pull these out of here, get them access to q some other way
 	q.L.SetFuncs(queueNamespace, map[string]lua.LGFunction{
@@ -56,18 +178,68 @@ func (q *Queue) populateAPI() {
 			q.L.Push(lua.LString(q.Def.Title))
 			return 1
 		},
+		// queue.Log(message [, level]) logs for the script, tagged with the
+		// queue; level is debug, info (the default), warn or error
+		"Log": func(L *lua.LState) int {
+			message := L.CheckString(1)
+			entry := log.WithFields(log.Fields{
+				"event": "queue.Log",
+				"queue": q.Def.Name,
+			})
+
+			switch level := L.OptString(2, "info"); level {
+			case "debug":
+				entry.Debug(message)
+			case "info":
+				entry.Info(message)
+			case "warn":
+				entry.Warn(message)
+			case "error":
+				entry.Error(message)
+			default:
+				L.ArgError(2, fmt.Sprintf("unknown level %q: want debug, info, warn or error", level))
+			}
+			return 0
+		},
+		// how many players a match from this queue has, at least and at most
+		"GetPlayerLimits": func(L *lua.LState) int {
+			L.Push(lua.LNumber(q.Def.MinPlayers))
+			L.Push(lua.LNumber(q.Def.MaxPlayers))
+			return 2
+		},
 		"GetPlayerList": func(L *lua.LState) int {
 			tab := L.NewTable()
-			q.playersMut.Lock()
-			defer q.playersMut.Unlock()
 			for name, player := range q.players {
-				if player.Status() == Waiting {
+				if player.Status() == Waiting && !q.inGame(name) {
 					tab.Append(lua.LString(name))
 				}
 			}
 			q.L.Push(tab)
 			return 1
 		},
+		"GetPlayerInfo": func(L *lua.LState) int {
+			name := L.CheckString(1)
+			user, ok := q.users(name)
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+
+			info := L.NewTable()
+			info.RawSetString("name", lua.LString(user.Name))
+			info.RawSetString("country", lua.LString(user.Country))
+			info.RawSetString("lobbyID", lua.LString(user.LobbyID))
+			info.RawSetString("rank", lua.LNumber(user.Status.Rank()))
+			info.RawSetString("inGame", lua.LBool(user.Status.InGame()))
+			info.RawSetString("away", lua.LBool(user.Status.Away()))
+			info.RawSetString("bot", lua.LBool(user.Status.Bot()))
+			info.RawSetString("moderator", lua.LBool(user.Status.Moderator()))
+			if rating, ok := q.ratings(name); ok {
+				info.RawSetString("rating", lua.LNumber(rating))
+			}
+			L.Push(info)
+			return 1
+		},
 		"GetMapList": func(L *lua.LState) int {
 			tab := L.NewTable()
 			for _, mapName := range q.Def.MapNames {
@@ -76,6 +248,22 @@ func (q *Queue) populateAPI() {
 			q.L.Push(tab)
 			return 1
 		},
+		"PickGame": func(L *lua.LState) int {
+			gameName, reason, err := q.pickGame()
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			L.Push(lua.LString(gameName))
+			L.Push(lua.LString(reason))
+			return 2
+		},
+		"GetMatchHistory": func(L *lua.LState) int {
+			L.Push(q.luaHistory())
+			return 1
+		},
 		"GetGameList": func(L *lua.LState) int {
 			tab := L.NewTable()
 			for _, gameName := range q.Def.GameNames {
@@ -84,6 +272,28 @@ func (q *Queue) populateAPI() {
 			q.L.Push(tab)
 			return 1
 		},
+		"PickMap": func(L *lua.LState) int {
+			names := L.CheckTable(1)
+
+			players := []*Player{}
+			names.ForEach(func(_ lua.LValue, name lua.LValue) {
+				player, ok := q.players[lua.LVAsString(name)]
+				if ok {
+					players = append(players, player)
+				}
+			})
+
+			mapName, reason, err := q.pickMap(players)
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			L.Push(lua.LString(mapName))
+			L.Push(lua.LString(reason))
+			return 2
+		},
 		// TODO: un-uglify
 		"NewMatch": func(L *lua.LState) int {
 			match := L.ToTable(1)
@@ -94,16 +304,24 @@ func (q *Queue) populateAPI() {
 				return 0
 			}
 
+			// optional: why the script chose this map, for the match history
+			mapReason := lua.LVAsString(L.GetField(match, "mapReason"))
+			gameReason := lua.LVAsString(L.GetField(match, "gameReason"))
+
 			gameName, ok := L.GetField(match, "game").(lua.LString)
 			if !ok {
 				log.Warn("omg bad")
 				return 0
 			}
 
-			//TODO: don't make the lua do this; it is probably only one version, and obvious
-			engine, ok := L.GetField(match, "engineVersion").(lua.LString)
-			if !ok {
-				log.Warn("omg bad")
+			// optional: most queues only run one engine version
+			engine, err := q.engineVersion(lua.LVAsString(L.GetField(match, "engineVersion")))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event": "queue.NewMatch",
+					"queue": q.Def.Name,
+					"error": err,
+				}).Warn("bad engine version in match")
 				return 0
 			}
 
@@ -113,10 +331,8 @@ func (q *Queue) populateAPI() {
 				return 0
 			}
 
-			q.playersMut.Lock()
-			defer q.playersMut.Unlock()
-
 			matchPlayers := []*Player{}
+			seats := []*Seat{}
 			errors := []string{}
 			playersTable.ForEach(func(i lua.LValue, player lua.LValue) {
 
@@ -138,84 +354,125 @@ func (q *Queue) populateAPI() {
 					return
 				}
 
-				team, ok := L.GetField(player, "team").(lua.LNumber)
-				if !ok {
-					errors = append(errors, fmt.Sprintf("player %s does not have a team!", name))
+				seat, err := luaSeat(L, player)
+				if err != nil {
+					errors = append(errors, fmt.Sprintf("player %s %v", name, err))
 					return
 				}
 
-				allyTeam, ok := L.GetField(player, "ally").(lua.LNumber)
-				if !ok {
-					errors = append(errors, fmt.Sprintf("player %s does not have an allyteam!", name))
-					return
-				}
-
-				queuePlayer.SetMatched(int(team), int(allyTeam))
-
 				matchPlayers = append(matchPlayers, queuePlayer)
+				seats = append(seats, seat)
 			})
 
+			script, err := luaScriptOptions(L, L.GetField(match, "script"))
+			if err != nil {
+				errors = append(errors, err.Error())
+			}
+
 			if len(errors) > 0 {
-				log.Warn("bailing on this match, errors present, %v", errors)
+				log.Warnf("bailing on this match, errors present, %v", errors)
 				return 0
 			}
 
-			q.Matches <- &Match{
+			// only claim the players once the whole match is known to be good
+			for i, player := range matchPlayers {
+				player.SetMatched(seats[i])
+			}
+
+			// handed over in the background unless there's room right away: the
+			// queue's goroutine must never wait on the matchbot, which may well
+			// be waiting on the queue
+			newMatch := &Match{
 				Id:            q.newMatchId(),
 				QueueName:     q.Def.Name,
 				Map:           string(mapName),
+				MapReason:     mapReason,
+				GameReason:    gameReason,
 				Game:          string(gameName),
-				EngineVersion: string(engine),
+				EngineVersion: engine,
 				Players:       matchPlayers,
+				Script:        q.Def.Script.Merge(script),
+			}
+			select {
+			case q.Matches <- newMatch:
+			default:
+				go func() {
+					select {
+					case q.Matches <- newMatch:
+					case <-q.closed:
+					}
+				}()
 			}
 
-			return 0
+			// so the script can recognize the match in later callins
+			L.Push(lua.LNumber(newMatch.Id))
+			return 1
 		},
 	})
 
+	q.L.SetField(queueNamespace, "util", q.utilAPI())
+	q.L.SetField(queueNamespace, "Store", q.storeAPI())
 	q.L.SetGlobal("queue", queueNamespace)
 
+	// scripts time players' waits with os.time(): keep it on the queue's clock
+	osTable, ok := q.L.GetGlobal("os").(*lua.LTable)
+	if ok {
+		osTime := osTable.RawGetString("time")
+		q.L.SetField(osTable, "time", q.L.NewFunction(func(L *lua.LState) int {
+			if L.GetTop() > 0 {
+				// a date table to convert, which has nothing to do with the clock
+				L.CallByParam(lua.P{Fn: osTime, NRet: 1}, L.Get(1))
+				return 1
+			}
+			L.Push(lua.LNumber(q.clock.Now().Unix()))
+			return 1
+		}))
+	}
+
 }
 
 // AddPlayer adds a player to the queue, triggering the queue.PlayerJoined Lua callback.
-func (q *Queue) AddPlayer(name string) error {
+func (q *Queue) AddPlayer(name string, vote MapVote) error {
 	player := NewPlayer(name)
+	player.MapVote = vote
 
-	q.LMut.Lock()
-	defer q.LMut.Unlock()
-
-	// protected by the LMut
-	q.players[name] = player
+	return q.do(func() error {
+		q.players[name] = player
 
-	callin, err := q.getLuaCallin("PlayerJoined")
-	if err != nil {
-		return fmt.Errorf("queue.AddPlayer: cannot get lua callin %v: %v", "PlayerJoined", err)
-	}
+		callin, err := q.getLuaCallin("PlayerJoined")
+		if err != nil {
+			return fmt.Errorf("queue.AddPlayer: cannot get lua callin %v: %v", "PlayerJoined", err)
+		}
 
-	err = q.L.CallByParam(lua.P{
-		Fn:      callin,
-		NRet:    0,
-		Protect: true,
-	}, lua.LString(name))
+		err = q.L.CallByParam(lua.P{
+			Fn:      callin,
+			NRet:    0,
+			Protect: true,
+		}, lua.LString(name))
 
-	if err != nil {
-		return fmt.Errorf("queue.AddPlayer: error calling 'PlayerJoined': %v", err)
-	}
+		if err != nil {
+			return fmt.Errorf("queue.AddPlayer: error calling 'PlayerJoined': %v", err)
+		}
 
-	return nil
+		// a match may be possible right away
+		q.trigger()
+		return nil
+	})
 }
 
 // RemovePlayer drops a player from the queue. this happens on: user action, user client disconnect, or ready check failure. it triggers the queue.PlayerLeft Lua callback
 func (q *Queue) RemovePlayer(name string) error {
+	return q.do(func() error {
+		return q.removePlayer(name)
+	})
+}
+
+func (q *Queue) removePlayer(name string) error {
 	_, ok := q.players[name]
 	if !ok {
 		return fmt.Errorf("queue.RemovePlayer: asked to remove player who is not in the queue")
 	}
 
-	q.LMut.Lock()
-	defer q.LMut.Unlock()
-
-	// protected by the LMut
 	delete(q.players, name)
 
 	callin, err := q.getLuaCallin("PlayerLeft")
@@ -233,33 +490,240 @@ func (q *Queue) RemovePlayer(name string) error {
 		return fmt.Errorf("queue.RemovePlayer: error calling 'PlayerLeft': %v", err)
 	}
 
+	// whoever is left may match differently now
+	q.trigger()
 	return nil
 }
 
-func (q *Queue) luaUpdateCallin() {
-	startTime := time.Now()
+// Start runs the queue until ctx is done, or Stop or Close is called. a
+// stopped queue may be started again; its players and Lua state are kept.
+func (q *Queue) Start(ctx context.Context) error {
+	q.lifecycleMut.Lock()
+	defer q.lifecycleMut.Unlock()
+
+	select {
+	case <-q.closed:
+		return ErrClosed
+	default:
+	}
+
+	if q.done != nil {
+		return fmt.Errorf("queue.Start: queue %v is already running", q.name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	q.cancel = cancel
+	q.done = done
+
+	go q.run(ctx, done)
+	return nil
+}
+
+// Stop halts the queue's goroutine, and waits for it to finish whatever it
+// is doing. it must not be called from a Lua callin, nor from anything a
+// callin waits on: the callin would be waiting for Stop, and Stop for the
+// callin. cancel the context given to Start instead, which doesn't wait.
+func (q *Queue) Stop() {
+	q.lifecycleMut.Lock()
+	cancel, done := q.cancel, q.done
+	q.lifecycleMut.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// Close stops the queue for good, and frees its Lua state. like Stop, it
+// waits for the queue's goroutine, so the same goes for calling it from a
+// callin.
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		q.lifecycleMut.Lock()
+		close(q.closed)
+		q.lifecycleMut.Unlock()
+
+		q.Stop()
+		q.L.Close()
+	})
+}
+
+// run owns the queue's state: it carries out work handed over by do, and
+// calls queue.Update every interval, until ctx is done.
+func (q *Queue) run(ctx context.Context, done chan struct{}) {
+	var ticker clock.Ticker
+	// a nil channel never fires: manual queues, and scripts without an
+	// Update callin, only update when something happens
+	var ticks <-chan time.Time
+	var interval time.Duration
+
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		// q.triggered is still set, so a restart picks the TryMatch back up
+		if q.debounce != nil {
+			q.debounce.Stop()
+			q.debounce = nil
+		}
+
+		q.lifecycleMut.Lock()
+		q.cancel()
+		q.cancel = nil
+		q.done = nil
+		q.lifecycleMut.Unlock()
+		close(done)
+	}()
+
 	for {
-		q.LMut.Lock()
-		callin, err := q.getLuaCallin("Update")
-		if err != nil {
-			log.Error("queue.RemovePlayer: cannot get lua callin %v: %v", "Update", err)
-			q.LMut.Unlock()
-			continue
+		// Update may have changed the interval
+		if !q.manual && q.updates && q.interval != interval {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			interval = q.interval
+			ticker = q.clock.NewTicker(interval)
+			ticks = ticker.C()
 		}
 
-		elapsedSeconds := int(time.Since(startTime).Seconds())
+		var debounced <-chan time.Time
+		if q.debounce != nil {
+			debounced = q.debounce.C()
+		}
 
-		err = q.L.CallByParam(lua.P{
-			Fn:      callin,
-			NRet:    0,
-			Protect: true,
-		}, lua.LNumber(elapsedSeconds))
+		select {
+		case action := <-q.actions:
+			action()
+		case <-ticks:
+			q.luaUpdateCallin(q.elapsedSeconds())
+		case <-debounced:
+			q.debounce = nil
+			q.luaTryMatchCallin()
+		case <-ctx.Done():
+			return
+		}
 
-		q.LMut.Unlock()
-		time.Sleep(1 * time.Second)
+		if q.triggered && q.debounce == nil {
+			if q.manual {
+				// whoever drives a manual queue wants to see the effect of
+				// each step before taking the next one
+				q.luaTryMatchCallin()
+			} else {
+				q.debounce = q.clock.NewTimer(tryMatchDelay)
+			}
+		}
 	}
 }
 
+// do runs fn on the queue's goroutine, and waits for it to finish.
+func (q *Queue) do(fn func() error) error {
+	q.lifecycleMut.Lock()
+	done := q.done
+	q.lifecycleMut.Unlock()
+
+	if done == nil {
+		select {
+		case <-q.closed:
+			return ErrClosed
+		default:
+			return ErrStopped
+		}
+	}
+
+	result := make(chan error, 1)
+	action := func() {
+		result <- fn()
+	}
+
+	select {
+	case q.actions <- action:
+		return <-result
+	case <-done:
+		return ErrStopped
+	}
+}
+
+// Tick calls queue.Update once, and waits for it to finish. it's for queues
+// with Options.Manual, which have no timer of their own.
+func (q *Queue) Tick() error {
+	return q.do(func() error {
+		q.luaUpdateCallin(q.elapsedSeconds())
+		return nil
+	})
+}
+
+func (q *Queue) elapsedSeconds() int {
+	return int(q.clock.Now().Sub(q.started).Seconds())
+}
+
+// luaUpdateCallin calls queue.Update, if the script has one: it's optional.
+func (q *Queue) luaUpdateCallin(elapsedSeconds int) {
+	if !q.updates {
+		return
+	}
+
+	callin, err := q.getLuaCallin("Update")
+	if err != nil {
+		log.Errorf("queue.luaUpdateCallin: cannot get lua callin %v: %v", "Update", err)
+		return
+	}
+
+	err = q.L.CallByParam(lua.P{
+		Fn:      callin,
+		NRet:    0,
+		Protect: true,
+	}, lua.LNumber(elapsedSeconds))
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": "queue.luaUpdateCallin",
+			"queue": q.Def.Name,
+			"error": err,
+		}).Warn("error calling 'Update'")
+	}
+}
+
+// Name is the queue's name, which never changes.
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Update swaps in a new definition: the Lua script sees the new maps, games,
+// etc. from its next callin on. the name must stay the same.
+func (q *Queue) Update(def *Definition) error {
+	if def.Name != q.name {
+		return fmt.Errorf("queue.Update: cannot rename queue %v to %v", q.name, def.Name)
+	}
+
+	interval, err := def.Interval()
+	if err != nil {
+		return fmt.Errorf("queue.Update: queue %v: %v", q.name, err)
+	}
+
+	return q.do(func() error {
+		if def.ScriptFile() != q.Def.ScriptFile() {
+			return fmt.Errorf("queue.Update: cannot switch queue %v to a different script; close and reopen it", q.name)
+		}
+
+		q.Def = def
+		q.interval = interval
+		return nil
+	})
+}
+
+// Definition is the queue's current definition, or nil if it isn't running.
+func (q *Queue) Definition() *Definition {
+	var def *Definition
+	q.do(func() error {
+		def = q.Def
+		return nil
+	})
+	return def
+}
+
 func (q *Queue) getLuaCallin(name string) (*lua.LFunction, error) {
 	namespace := q.L.GetGlobal("queue")
 	potentialCallin := q.L.GetField(namespace, name)
@@ -272,6 +736,36 @@ func (q *Queue) getLuaCallin(name string) (*lua.LFunction, error) {
 	return callin, nil
 }
 
+// inGame is true if the lobby says the player is already playing a game.
+func (q *Queue) inGame(name string) bool {
+	user, ok := q.users(name)
+	return ok && user.Status.InGame()
+}
+
+// engineVersion checks a Lua-requested engine version against the queue
+// definition. an empty request means the queue's first (usually only) version.
+func (q *Queue) engineVersion(requested string) (string, error) {
+	if len(q.Def.EngineVersions) == 0 {
+		return "", fmt.Errorf("queue %v has no engine versions defined", q.Def.Name)
+	}
+
+	if requested == "" {
+		return q.Def.EngineVersions[0], nil
+	}
+
+	for _, version := range q.Def.EngineVersions {
+		if version == requested {
+			return version, nil
+		}
+	}
+
+	return "", fmt.Errorf("engine version %q is not one of queue %v's versions %v", requested, q.Def.Name, q.Def.EngineVersions)
+}
+
+// newMatchId hands out the next match id, and stores it so a restarted queue
+// doesn't hand it out again. it runs on the queue's goroutine.
 func (q *Queue) newMatchId() uint64 {
-	return atomic.AddUint64(&q.matchId, 1)
+	id := atomic.AddUint64(&q.matchId, 1)
+	q.saveState(matchIdKey, id)
+	return id
 }
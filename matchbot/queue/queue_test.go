@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEngineVersion(t *testing.T) {
+	cases := []struct {
+		name      string
+		versions  []string
+		requested string
+		want      string
+		problem   string
+	}{
+		{"default is the first", []string{"103.0", "104.0"}, "", "103.0", ""},
+		{"requested", []string{"103.0", "104.0"}, "104.0", "104.0", ""},
+		{"not the queue's", []string{"103.0"}, "104.0", "", `engine version "104.0" is not one of`},
+		{"none defined", nil, "", "", "no engine versions defined"},
+	}
+
+	for _, c := range cases {
+		def := &Definition{}
+		def.Name = "1v1"
+		def.EngineVersions = c.versions
+		q := &Queue{Def: def}
+
+		got, err := q.engineVersion(c.requested)
+		if c.problem == "" {
+			if err != nil || got != c.want {
+				t.Errorf("%v: got %q, %v; want %q", c.name, got, err, c.want)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%v: got %q, %v; want an error mentioning %q", c.name, got, err, c.problem)
+		}
+	}
+}
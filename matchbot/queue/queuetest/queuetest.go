@@ -0,0 +1,48 @@
+// Package queuetest has the queue scripts and definitions shared by the
+// tests of queues and of the code that hosts them.
+package queuetest
+
+import (
+	_ "embed"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// PairScript matches whoever is waiting as soon as there are two of them,
+// and stores why their ready check failed under "failed". those who were
+// ready wait again.
+//
+//go:embed testdata/pair.lua
+var PairScript string
+
+// IdleScript accepts everyone and never matches anyone.
+//
+//go:embed testdata/idle.lua
+var IdleScript string
+
+// Queue is a 1v1 queue with one map, game and engine version: the ones
+// PairScript matches on.
+func Queue(name string) protocol.QueueDefinition {
+	return protocol.QueueDefinition{
+		Name:           name,
+		Title:          name,
+		MinPlayers:     2,
+		MaxPlayers:     2,
+		MapNames:       []string{"DeltaSiegeDry"},
+		GameNames:      []string{"Balanced Annihilation V9.46"},
+		EngineVersions: []string{"103.0"},
+	}
+}
+
+// Script writes a queue script to a file of its own, which lasts as long as
+// the test, and returns its path.
+func Script(t testing.TB, source string) string {
+	file := filepath.Join(t.TempDir(), "queue.lua")
+	err := os.WriteFile(file, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
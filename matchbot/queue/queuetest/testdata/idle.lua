@@ -0,0 +1,4 @@
+-- accepts everyone and never matches anyone
+function queue.PlayerJoined(name) end
+function queue.PlayerLeft(name) end
+function queue.Update() end
@@ -0,0 +1,51 @@
+-- matches whoever is waiting as soon as there are two of them, and notes
+-- down why their ready check failed. those who were ready wait again.
+local waiting = {}
+
+function queue.PlayerJoined(name)
+	table.insert(waiting, name)
+end
+
+function queue.PlayerLeft(name)
+	for i, waiter in ipairs(waiting) do
+		if waiter == name then
+			table.remove(waiting, i)
+			return
+		end
+	end
+end
+
+function queue.TryMatch()
+	if #waiting < 2 then
+		return
+	end
+
+	local seats = {}
+	for i, name in ipairs(waiting) do
+		table.insert(seats, { name = name, team = i - 1, ally = i - 1 })
+	end
+	waiting = {}
+
+	queue.NewMatch({
+		map = "DeltaSiegeDry",
+		game = "Balanced Annihilation V9.46",
+		players = seats
+	})
+end
+
+function queue.ReadyCheckFailed(match, reason)
+	local names = {}
+	local unready = {}
+	for _, name in ipairs(match.unready) do
+		table.insert(names, name)
+		unready[name] = true
+	end
+	table.sort(names)
+
+	for _, player in ipairs(match.players) do
+		if not unready[player.name] then
+			table.insert(waiting, player.name)
+		end
+	end
+	queue.Store.Set("failed", reason .. ": " .. table.concat(names, ","))
+end
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+)
+
+// luaSeat reads a player entry from a NewMatch call: 'team' and 'ally' are
+// required unless 'spectator' is true; 'side', 'color' ({r, g, b}, each 0 to
+// 1) and 'handicap' are optional.
+func luaSeat(L *lua.LState, player lua.LValue) (*Seat, error) {
+	if lua.LVAsBool(L.GetField(player, "spectator")) {
+		return &Seat{Spectator: true}, nil
+	}
+
+	team, ok := L.GetField(player, "team").(lua.LNumber)
+	if !ok {
+		return nil, fmt.Errorf("does not have a team")
+	}
+
+	allyTeam, ok := L.GetField(player, "ally").(lua.LNumber)
+	if !ok {
+		return nil, fmt.Errorf("does not have an allyteam")
+	}
+
+	seat := &Seat{
+		Team:     int(team),
+		AllyTeam: int(allyTeam),
+		Side:     lua.LVAsString(L.GetField(player, "side")),
+		Handicap: int(lua.LVAsNumber(L.GetField(player, "handicap"))),
+	}
+
+	switch color := L.GetField(player, "color").(type) {
+	case *lua.LNilType:
+	case *lua.LTable:
+		if color.Len() != 3 {
+			return nil, fmt.Errorf("color must be {r, g, b}")
+		}
+		for i := 1; i <= 3; i++ {
+			seat.Color = append(seat.Color, float64(lua.LVAsNumber(color.RawGetInt(i))))
+		}
+	default:
+		return nil, fmt.Errorf("color must be a table, not %v", color.Type())
+	}
+
+	return seat, nil
+}
+
+// luaScriptOptions reads the optional 'script' table of a NewMatch call,
+// which overrides the queue's startscript defaults for that match.
+func luaScriptOptions(L *lua.LState, script lua.LValue) (ScriptOptions, error) {
+	opts := ScriptOptions{}
+	if script == lua.LNil {
+		return opts, nil
+	}
+
+	if _, ok := script.(*lua.LTable); !ok {
+		return opts, fmt.Errorf("script must be a table, not %v", script.Type())
+	}
+
+	if startPosType, ok := L.GetField(script, "startPosType").(lua.LNumber); ok {
+		n := int(startPosType)
+		opts.StartPosType = &n
+	}
+
+	var err error
+	opts.ModOptions, err = luaStringMap(L.GetField(script, "modOptions"))
+	if err != nil {
+		return opts, fmt.Errorf("modOptions: %v", err)
+	}
+
+	opts.MapOptions, err = luaStringMap(L.GetField(script, "mapOptions"))
+	if err != nil {
+		return opts, fmt.Errorf("mapOptions: %v", err)
+	}
+
+	if restrictions, ok := L.GetField(script, "restrictions").(*lua.LTable); ok {
+		opts.Restrictions = map[string]int{}
+		restrictions.ForEach(func(unit lua.LValue, limit lua.LValue) {
+			opts.Restrictions[lua.LVAsString(unit)] = int(lua.LVAsNumber(limit))
+		})
+	}
+
+	if boxes, ok := L.GetField(script, "startBoxes").(*lua.LTable); ok {
+		opts.StartBoxes = map[int]StartBox{}
+		boxes.ForEach(func(allyTeam lua.LValue, box lua.LValue) {
+			opts.StartBoxes[int(lua.LVAsNumber(allyTeam))] = StartBox{
+				Left:   float64(lua.LVAsNumber(L.GetField(box, "left"))),
+				Top:    float64(lua.LVAsNumber(L.GetField(box, "top"))),
+				Right:  float64(lua.LVAsNumber(L.GetField(box, "right"))),
+				Bottom: float64(lua.LVAsNumber(L.GetField(box, "bottom"))),
+			}
+		})
+	}
+
+	return opts, nil
+}
+
+func luaStringMap(value lua.LValue) (map[string]string, error) {
+	if value == lua.LNil {
+		return nil, nil
+	}
+
+	tab, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("must be a table, not %v", value.Type())
+	}
+
+	result := map[string]string{}
+	tab.ForEach(func(k lua.LValue, v lua.LValue) {
+		result[lua.LVAsString(k)] = v.String()
+	})
+	return result, nil
+}
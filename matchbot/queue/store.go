@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yuin/gopher-lua"
+)
+
+// values nest no deeper than this, which also catches tables holding themselves
+const maxStoreDepth = 32
+
+// storeAPI exposes the queue's persistent state to Lua as queue.Store, in a
+// namespace of its own. values may be nil, booleans, numbers, strings, and
+// tables of those; they are kept as JSON.
+func (q *Queue) storeAPI() *lua.LTable {
+	api := q.L.NewTable()
+	q.L.SetFuncs(api, map[string]lua.LGFunction{
+		// Get(key) returns the value, or nil if there isn't one
+		"Get": func(L *lua.LState) int {
+			raw, ok, err := q.store.Get(q.name, L.CheckString(1))
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+
+			var value interface{}
+			err = json.Unmarshal([]byte(raw), &value)
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(fmt.Sprintf("stored value is not valid JSON: %v", err)))
+				return 2
+			}
+
+			L.Push(toLua(L, value))
+			return 1
+		},
+		// Set(key, value) returns true, or nil and an error. a nil value
+		// deletes the key
+		"Set": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			value := L.Get(2)
+
+			var err error
+			if value == lua.LNil {
+				err = q.store.Delete(q.name, key)
+			} else {
+				var b []byte
+				var v interface{}
+				v, err = fromLua(value, 0)
+				if err == nil {
+					b, err = json.Marshal(v)
+				}
+				if err == nil {
+					err = q.store.Set(q.name, key, string(b))
+				}
+			}
+
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			L.Push(lua.LTrue)
+			return 1
+		},
+		// Keys() returns every key with a value, sorted
+		"Keys": func(L *lua.LState) int {
+			keys, err := q.store.Keys(q.name)
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+
+			tab := L.NewTable()
+			for _, key := range keys {
+				tab.Append(lua.LString(key))
+			}
+			L.Push(tab)
+			return 1
+		},
+	})
+	return api
+}
+
+// fromLua converts a Lua value into something encoding/json can take. tables
+// with only the keys 1..n become lists; any other table needs string keys.
+func fromLua(value lua.LValue, depth int) (interface{}, error) {
+	if depth > maxStoreDepth {
+		return nil, fmt.Errorf("value nests deeper than %v tables", maxStoreDepth)
+	}
+
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		n := v.MaxN()
+		count := 0
+		v.ForEach(func(lua.LValue, lua.LValue) {
+			count++
+		})
+
+		if n > 0 && n == count {
+			list := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				item, err := fromLua(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list[i-1] = item
+			}
+			return list, nil
+		}
+
+		object := map[string]interface{}{}
+		var err error
+		v.ForEach(func(key lua.LValue, item lua.LValue) {
+			if err != nil {
+				return
+			}
+
+			name, ok := key.(lua.LString)
+			if !ok {
+				err = fmt.Errorf("table keys must be strings (or 1..n for a list), not %v", key.Type())
+				return
+			}
+
+			object[string(name)], err = fromLua(item, depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	default:
+		return nil, fmt.Errorf("cannot store a %v", value.Type())
+	}
+}
+
+// toLua converts decoded JSON back into Lua values.
+func toLua(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		tab := L.NewTable()
+		for _, item := range v {
+			tab.Append(toLua(L, item))
+		}
+		return tab
+	case map[string]interface{}:
+		tab := L.NewTable()
+		for key, item := range v {
+			tab.RawSetString(key, toLua(L, item))
+		}
+		return tab
+	default:
+		return lua.LNil
+	}
+}
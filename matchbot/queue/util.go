@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/matchmaking"
+	"github.com/yuin/gopher-lua"
+	"time"
+)
+
+// utilAPI exposes the matchmaking package to Lua as queue.util. candidates
+// are tables with 'name', 'rating' and 'waited' (seconds) fields; results hand
+// back the same tables, so scripts can keep their own data on them.
+func (q *Queue) utilAPI() *lua.LTable {
+	util := q.L.NewTable()
+	q.L.SetFuncs(util, map[string]lua.LGFunction{
+		"PairByRating": func(L *lua.LState) int {
+			cands, tables := checkCandidates(L, 1)
+			opts := L.OptTable(2, L.NewTable())
+			window := matchmaking.Window{
+				Base:   float64(lua.LVAsNumber(L.GetField(opts, "base"))),
+				Growth: float64(lua.LVAsNumber(L.GetField(opts, "growth"))),
+				Max:    float64(lua.LVAsNumber(L.GetField(opts, "max"))),
+			}
+
+			pairs, rest := matchmaking.PairByRating(cands, window)
+			result := L.NewTable()
+			for _, pair := range pairs {
+				result.Append(candidateTable(L, tables, pair[:]))
+			}
+
+			L.Push(result)
+			L.Push(candidateTable(L, tables, rest))
+			return 2
+		},
+		"BalanceGreedy": func(L *lua.LState) int {
+			cands, tables := checkCandidates(L, 1)
+			teams, err := matchmaking.BalanceGreedy(cands, L.CheckInt(2))
+			return pushTeams(L, tables, teams, err)
+		},
+		"BalanceExhaustive": func(L *lua.LState) int {
+			cands, tables := checkCandidates(L, 1)
+			teams, err := matchmaking.BalanceExhaustive(cands, L.CheckInt(2))
+			return pushTeams(L, tables, teams, err)
+		},
+		"BucketFFA": func(L *lua.LState) int {
+			cands, tables := checkCandidates(L, 1)
+			buckets, rest := matchmaking.BucketFFA(cands, L.CheckInt(2))
+			result := L.NewTable()
+			for _, bucket := range buckets {
+				result.Append(candidateTable(L, tables, bucket))
+			}
+
+			L.Push(result)
+			L.Push(candidateTable(L, tables, rest))
+			return 2
+		},
+		"MatchQuality": func(L *lua.LState) int {
+			teamsTable := L.CheckTable(1)
+			teams := [][]matchmaking.Candidate{}
+			teamsTable.ForEach(func(_ lua.LValue, team lua.LValue) {
+				members, ok := team.(*lua.LTable)
+				if !ok {
+					L.ArgError(1, "each team must be a table of candidates")
+				}
+				cands, _ := toCandidates(L, 1, members)
+				teams = append(teams, cands)
+			})
+
+			L.Push(lua.LNumber(matchmaking.Quality(teams)))
+			return 1
+		},
+	})
+
+	return util
+}
+
+func checkCandidates(L *lua.LState, n int) ([]matchmaking.Candidate, map[string]lua.LValue) {
+	return toCandidates(L, n, L.CheckTable(n))
+}
+
+func toCandidates(L *lua.LState, n int, list *lua.LTable) ([]matchmaking.Candidate, map[string]lua.LValue) {
+	cands := []matchmaking.Candidate{}
+	tables := map[string]lua.LValue{}
+	list.ForEach(func(i lua.LValue, entry lua.LValue) {
+		name, ok := L.GetField(entry, "name").(lua.LString)
+		if !ok {
+			L.ArgError(n, fmt.Sprintf("candidate %v does not have a name", i))
+		}
+
+		if _, dup := tables[string(name)]; dup {
+			L.ArgError(n, fmt.Sprintf("candidate %s appears more than once", name))
+		}
+
+		rating, ok := L.GetField(entry, "rating").(lua.LNumber)
+		if !ok {
+			L.ArgError(n, fmt.Sprintf("candidate %s does not have a rating", name))
+		}
+
+		waited := lua.LVAsNumber(L.GetField(entry, "waited"))
+
+		tables[string(name)] = entry
+		cands = append(cands, matchmaking.Candidate{
+			Name:   string(name),
+			Rating: float64(rating),
+			Waited: time.Duration(float64(waited) * float64(time.Second)),
+		})
+	})
+
+	return cands, tables
+}
+
+func candidateTable(L *lua.LState, tables map[string]lua.LValue, cands []matchmaking.Candidate) *lua.LTable {
+	tab := L.NewTable()
+	for _, c := range cands {
+		tab.Append(tables[c.Name])
+	}
+	return tab
+}
+
+func pushTeams(L *lua.LState, tables map[string]lua.LValue, teams [][]matchmaking.Candidate, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	result := L.NewTable()
+	for _, team := range teams {
+		result.Append(candidateTable(L, tables, team))
+	}
+	L.Push(result)
+	return 1
+}
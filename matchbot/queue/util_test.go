@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"github.com/yuin/gopher-lua"
+	"testing"
+)
+
+// the checks live in the script: a failed assert fails DoString
+const utilScript = `
+local a = { name = "a", rating = 1500, waited = 30, note = "kept" }
+local b = { name = "b", rating = 1560 }
+local c = { name = "c", rating = 1520 }
+local d = { name = "d", rating = 1900 }
+
+local matched, rest = util.PairByRating({ a, b, c }, { base = 100, growth = 10, max = 300 })
+assert(#matched == 1 and matched[1][1] == a and matched[1][2] == c, "a should pair with c")
+assert(#rest == 1 and rest[1] == b, "b should be left over")
+assert(matched[1][1].note == "kept", "candidates should come back as the tables passed in")
+
+local teams = util.BalanceGreedy({ a, b, c, d }, 2)
+assert(#teams == 2, "want 2 teams")
+assert(teams[1][1] == d and teams[1][2] == a, "team 1 should be d and a")
+assert(teams[2][1] == b and teams[2][2] == c, "team 2 should be b and c")
+
+local none, err = util.BalanceExhaustive({ a, b, c }, 2)
+assert(none == nil and err:find("split evenly"), "3 players can't make 2 teams")
+
+local buckets, leftover = util.BucketFFA({ a, b, c, d }, 3)
+assert(#buckets == 1 and buckets[1][1] == d and buckets[1][2] == b and buckets[1][3] == c, "want d, b, c bucketed")
+assert(#leftover == 1 and leftover[1] == a, "a should be left over")
+
+assert(util.MatchQuality({ { a }, { a } }) == 1, "a mirror match is a coin flip")
+assert(math.abs(util.MatchQuality({ { a }, { d } }) - 2 / 11) < 1e-9, "400 points apart")
+
+local ok, problem = pcall(util.PairByRating, { { rating = 1500 } })
+assert(not ok and problem:find("does not have a name"), "nameless candidates are refused")
+
+ok, problem = pcall(util.BalanceGreedy, { a, a }, 2)
+assert(not ok and problem:find("more than once"), "duplicates are refused")
+`
+
+func TestUtilAPI(t *testing.T) {
+	q := &Queue{L: lua.NewState()}
+	defer q.L.Close()
+	q.L.SetGlobal("util", q.utilAPI())
+
+	err := q.L.DoString(utilScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
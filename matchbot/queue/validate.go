@@ -0,0 +1,363 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError is one problem with a queues file, pinned to the line it
+// was found on.
+type ValidationError struct {
+	Line int
+	// empty if the problem isn't with a particular queue
+	Queue   string
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Queue == "" {
+		return fmt.Sprintf("line %v: %v", e.Line, e.Problem)
+	}
+	return fmt.Sprintf("line %v: queue %q: %v", e.Line, e.Queue, e.Problem)
+}
+
+// ValidationErrors is everything wrong with a queues file.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseDefinitions decodes and checks a queues file: a JSON list of queue
+// definitions. it reports every problem it finds as ValidationErrors, rather
+// than stopping at the first, and returns no definitions if there are any.
+func ParseDefinitions(data []byte) ([]*Definition, error) {
+	v := &validator{data: data, names: map[string]int{}}
+	defs := v.definitions()
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return defs, nil
+}
+
+var (
+	definitionKeys = jsonKeys(reflect.TypeOf(Definition{}))
+	scriptKeys     = jsonKeys(reflect.TypeOf(ScriptOptions{}))
+)
+
+type validator struct {
+	data []byte
+	errs ValidationErrors
+	// queue name to the line it was first defined on
+	names map[string]int
+}
+
+// a key of a JSON object, with where it and its value start in the file
+type jsonKey struct {
+	name        string
+	offset      int64
+	value       json.RawMessage
+	valueOffset int64
+}
+
+func (v *validator) fail(offset int64, queue string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Line:    v.line(offset),
+		Queue:   queue,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) line(offset int64) int {
+	if offset > int64(len(v.data)) {
+		offset = int64(len(v.data))
+	}
+	return bytes.Count(v.data[:offset], []byte("\n")) + 1
+}
+
+func (v *validator) definitions() []*Definition {
+	dec := json.NewDecoder(bytes.NewReader(v.data))
+	tok, err := dec.Token()
+	if err != nil {
+		v.syntaxError(0, "", err)
+		return nil
+	}
+
+	if tok != json.Delim('[') {
+		v.fail(0, "", "the file must be a JSON list of queue definitions")
+		return nil
+	}
+
+	defs := []*Definition{}
+	for dec.More() {
+		start := skipSeparators(v.data, dec.InputOffset())
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err != nil {
+			v.syntaxError(0, "", err)
+			return nil
+		}
+
+		def := v.definition(raw, start)
+		if def != nil {
+			defs = append(defs, def)
+		}
+	}
+
+	_, err = dec.Token()
+	if err != nil {
+		v.syntaxError(0, "", err)
+	}
+	return defs
+}
+
+// definition checks a single queue definition; raw starts at start in the file.
+func (v *validator) definition(raw json.RawMessage, start int64) *Definition {
+	keys, ok := objectKeys(raw, start)
+	if !ok {
+		v.fail(start, "", "a queue definition must be a JSON object")
+		return nil
+	}
+
+	// a value of the wrong type leaves its field empty, and the rest decoded:
+	// that's still worth checking
+	def := &Definition{}
+	err := json.Unmarshal(raw, def)
+	if _, wrongType := err.(*json.UnmarshalTypeError); err != nil && !wrongType {
+		v.syntaxError(start, def.Name, err)
+		return nil
+	}
+
+	fields := map[string]jsonKey{}
+	// keys whose values didn't decode: each is reported once, here, and
+	// skipped by the checks below
+	broken := map[string]bool{}
+	for _, key := range keys {
+		fields[key.name] = key
+		if !definitionKeys[key.name] {
+			v.fail(key.offset, def.Name, "unknown key %q", key.name)
+			continue
+		}
+
+		if err != nil && !v.decodes(key, def.Name) {
+			broken[key.name] = true
+		}
+	}
+
+	if script, ok := fields["script"]; ok {
+		scriptFields, _ := objectKeys(script.value, script.valueOffset)
+		for _, key := range scriptFields {
+			if !scriptKeys[key.name] {
+				v.fail(key.offset, def.Name, "unknown key %q in script", key.name)
+			}
+		}
+	}
+
+	// point at the offending key where there is one, else the queue itself
+	at := func(name string) int64 {
+		key, ok := fields[name]
+		if !ok {
+			return start
+		}
+		return key.offset
+	}
+
+	if broken["name"] {
+		// already reported
+	} else if strings.TrimSpace(def.Name) == "" {
+		v.fail(at("name"), "", "queue has no name")
+	} else if strings.ContainsAny(def.Name, ReservedNameChars) {
+		v.fail(at("name"), def.Name, "queue names can't contain any of %q", ReservedNameChars)
+	} else if first, ok := v.names[def.Name]; ok {
+		v.fail(at("name"), def.Name, "duplicate queue name, first defined on line %v", first)
+	} else {
+		v.names[def.Name] = v.line(at("name"))
+	}
+
+	if broken["minPlayers"] {
+		// already reported
+	} else if def.MinPlayers <= 0 {
+		v.fail(at("minPlayers"), def.Name, "minPlayers (%v) must be at least 1", def.MinPlayers)
+	} else if !broken["maxPlayers"] && def.MaxPlayers < def.MinPlayers {
+		v.fail(at("maxPlayers"), def.Name, "maxPlayers (%v) is less than minPlayers (%v)", def.MaxPlayers, def.MinPlayers)
+	}
+
+	if !broken["mapNames"] && len(def.MapNames) == 0 {
+		v.fail(at("mapNames"), def.Name, "no maps in mapNames")
+	}
+
+	if !broken["gameNames"] && len(def.GameNames) == 0 {
+		v.fail(at("gameNames"), def.Name, "no games in gameNames")
+	}
+
+	if !broken["engineVersions"] && len(def.EngineVersions) == 0 {
+		v.fail(at("engineVersions"), def.Name, "no engine versions in engineVersions")
+	}
+
+	_, err = def.Interval()
+	if !broken["updateInterval"] && err != nil {
+		v.fail(at("updateInterval"), def.Name, "%v", err)
+	}
+
+	// a weight of 0 takes a choice out of rotation, and unlisted choices
+	// weigh 1: at least one choice has to be left to pick
+	weights := func(key string, namesKey string, kind string, names []string, weights map[string]int) {
+		if broken[key] || broken[namesKey] {
+			return
+		}
+
+		listed := make([]string, 0, len(weights))
+		for name := range weights {
+			listed = append(listed, name)
+		}
+		sort.Strings(listed)
+
+		for _, name := range listed {
+			if !contains(names, name) {
+				v.fail(at(key), def.Name, "%v has %v %q, which isn't in %v", key, kind, name, namesKey)
+			}
+			if weights[name] < 0 {
+				v.fail(at(key), def.Name, "%v has a negative weight (%v) for %v %q", key, weights[name], kind, name)
+			}
+		}
+
+		for _, name := range names {
+			if weight, ok := weights[name]; !ok || weight > 0 {
+				return
+			}
+		}
+		if len(names) > 0 {
+			v.fail(at(key), def.Name, "%v takes every %v in %v out of rotation", key, kind, namesKey)
+		}
+	}
+	weights("gameWeights", "gameNames", "game", def.GameNames, def.GameWeights)
+	weights("mapWeights", "mapNames", "map", def.MapNames, def.MapWeights)
+
+	return def
+}
+
+// decodes checks one key of a definition on its own, reporting it if its
+// value is the wrong type.
+func (v *validator) decodes(key jsonKey, queue string) bool {
+	name, _ := json.Marshal(key.name)
+	prefix := "{" + string(name) + ":"
+	single := append([]byte(prefix), key.value...)
+	single = append(single, '}')
+
+	err := json.Unmarshal(single, &Definition{})
+	if err == nil {
+		return true
+	}
+
+	// the error's offset is into single; the value's offset is into the file
+	v.syntaxError(key.valueOffset-int64(len(prefix)), queue, err)
+	return false
+}
+
+// syntaxError reports a decoding error at the position the decoder gives,
+// relative to base.
+func (v *validator) syntaxError(base int64, queue string, err error) {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		v.fail(base+e.Offset, queue, "invalid JSON: %v", e)
+	case *json.UnmarshalTypeError:
+		v.fail(base+e.Offset, queue, "%v should be %v, not a JSON %v", e.Field, e.Type, e.Value)
+	default:
+		v.fail(base+int64(len(v.data)), queue, "invalid JSON: %v", err)
+	}
+}
+
+// objectKeys lists the keys of a JSON object in order, and where each of
+// their values start in the file. ok is false if raw isn't an object.
+func objectKeys(raw json.RawMessage, start int64) (keys []jsonKey, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+
+	for dec.More() {
+		offset := skipSeparators(raw, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return keys, false
+		}
+
+		name, _ := tok.(string)
+		valueOffset := skipSeparators(raw, dec.InputOffset())
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return keys, false
+		}
+
+		keys = append(keys, jsonKey{
+			name:        name,
+			offset:      start + offset,
+			value:       value,
+			valueOffset: start + valueOffset,
+		})
+	}
+	return keys, true
+}
+
+// skipSeparators moves offset past whitespace, commas and colons: the
+// decoder reports the end of the last token, not the start of the next.
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// jsonKeys is the set of JSON keys a struct type decodes, including those of
+// embedded structs.
+func jsonKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for key := range jsonKeys(field.Type) {
+				keys[key] = true
+			}
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+func contains(list []string, item string) bool {
+	for _, candidate := range list {
+		if candidate == item {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckScript compiles a queue's Lua script, without running it.
+func CheckScript(file string) error {
+	L := lua.NewState()
+	defer L.Close()
+
+	_, err := L.LoadFile(file)
+	return err
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseDefinitions(t *testing.T) {
+	cases := []struct {
+		name string
+		file string
+		// each problem reported in order, as line: text fragment
+		problems []string
+	}{
+		{
+			name: "valid",
+			file: `[{"name": "1v1", "minPlayers": 2, "maxPlayers": 2,
+				"mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": ["103"]}]`,
+		},
+		{
+			name: "wrong type, the rest still checked",
+			file: `[
+				{"name": "1v1", "minPlayers": "two", "maxPlayers": 2,
+				 "mapNames": [], "gameNames": ["BA"], "engineVersions": ["103"]},
+				{"name": "1v1", "minPlayers": 4, "maxPlayers": 2,
+				 "mapNames": ["DeltaSiegeDry"], "gameNames": "BA", "engineVersions": ["103"]}
+			]`,
+			problems: []string{
+				`2: minPlayers should be int`,
+				`3: no maps in mapNames`,
+				`5: gameNames should be []string`,
+				`4: duplicate queue name`,
+				`4: maxPlayers (2) is less than minPlayers (4)`,
+			},
+		},
+		{
+			name: "every wrong type is reported",
+			file: `[{"name": 7, "minPlayers": 2, "maxPlayers": 2,
+				"mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": "103",
+				"script": {"startPosType": "random"}}]`,
+			problems: []string{
+				`1: name should be string`,
+				`2: engineVersions should be []string`,
+				`3: script.startPosType should be int`,
+			},
+		},
+		{
+			name: "player limits",
+			file: `[
+				{"name": "nobody", "maxPlayers": 2,
+				 "mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": ["103"]},
+				{"name": "negative", "minPlayers": -2, "maxPlayers": 2,
+				 "mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": ["103"]},
+				{"name": "backwards", "minPlayers": 4,
+				 "maxPlayers": 2,
+				 "mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": ["103"]}
+			]`,
+			problems: []string{
+				`2: minPlayers (0) must be at least 1`,
+				`4: minPlayers (-2) must be at least 1`,
+				`7: maxPlayers (2) is less than minPlayers (4)`,
+			},
+		},
+		{
+			name: "weights",
+			file: `[
+				{"name": "1v1", "minPlayers": 2, "maxPlayers": 2, "engineVersions": ["103"],
+				 "mapNames": ["DeltaSiegeDry", "Tabula"], "gameNames": ["BA", "S44"],
+				 "mapWeights": {"Tabula": -1, "Comet": 2},
+				 "gameWeights": {"BA": 0, "S44": 0}},
+				{"name": "2v2", "minPlayers": 4, "maxPlayers": 4, "engineVersions": ["103"],
+				 "mapNames": ["DeltaSiegeDry", "Tabula"], "gameNames": ["BA"],
+				 "mapWeights": {"DeltaSiegeDry": 0, "Tabula": 0},
+				 "gameWeights": {"BA": 0, "S44": 3}},
+				{"name": "3v3", "minPlayers": 6, "maxPlayers": 6, "engineVersions": ["103"],
+				 "mapNames": ["DeltaSiegeDry", "Tabula"], "gameNames": ["BA"],
+				 "mapWeights": {"DeltaSiegeDry": 0}}
+			]`,
+			problems: []string{
+				`5: gameWeights takes every game in gameNames out of rotation`,
+				`4: mapWeights has map "Comet", which isn't in mapNames`,
+				`4: mapWeights has a negative weight (-1) for map "Tabula"`,
+				`9: gameWeights has game "S44", which isn't in gameNames`,
+				`9: gameWeights takes every game in gameNames out of rotation`,
+				`8: mapWeights takes every map in mapNames out of rotation`,
+			},
+		},
+		{
+			name: "reserved name",
+			file: `[{"name": "queue:1v1", "minPlayers": 2, "maxPlayers": 2,
+				"mapNames": ["DeltaSiegeDry"], "gameNames": ["BA"], "engineVersions": ["103"]}]`,
+			problems: []string{`1: queue names can't contain any of ":"`},
+		},
+		{
+			name:     "syntax error",
+			file:     `[{"name": "1v1",}]`,
+			problems: []string{`1: invalid JSON`},
+		},
+	}
+
+	for _, c := range cases {
+		defs, err := ParseDefinitions([]byte(c.file))
+		if len(c.problems) == 0 {
+			if err != nil || len(defs) != 1 {
+				t.Errorf("%s: got %v, %v", c.name, defs, err)
+			}
+			continue
+		}
+
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Errorf("%s: want ValidationErrors, got %v", c.name, err)
+			continue
+		}
+
+		got := []string{}
+		for _, e := range errs {
+			got = append(got, e.Error())
+		}
+
+		if len(got) != len(c.problems) {
+			t.Errorf("%s: got %d problems, want %d:\n%s", c.name, len(got), len(c.problems), strings.Join(got, "\n"))
+			continue
+		}
+
+		for i, want := range c.problems {
+			parts := strings.SplitN(want, ": ", 2)
+			line, _ := strconv.Atoi(parts[0])
+			if errs[i].Line != line || !strings.Contains(errs[i].Problem, parts[1]) {
+				t.Errorf("%s: problem %d is %q, want %q", c.name, i, got[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package matchbot
+
+import (
+	"context"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+)
+
+// OpenQueue starts hosting a new queue. it is set up locally first, then the
+// server is asked to open it: that way players who join the instant the
+// server announces the queue have somewhere to go.
+func (m *Matchbot) OpenQueue(def *queue.Definition) error {
+	missing := m.engines.Missing(def.EngineVersions)
+	if len(missing) > 0 {
+		log.WithFields(log.Fields{
+			"event":   "matchbot.OpenQueue",
+			"queue":   def.Name,
+			"missing": missing,
+		}).Warn("queue uses engine versions which aren't installed: its matches will fail to start")
+	}
+
+	q, err := m.hostQueue(def)
+	if err != nil {
+		return fmt.Errorf("matchbot.OpenQueue: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	err = m.client.OpenQueue(ctx, &def.QueueDefinition)
+	if err != nil {
+		m.do(func() {
+			if m.queues[def.Name] == q {
+				delete(m.queues, def.Name)
+			}
+		})
+		q.Close()
+		return fmt.Errorf("matchbot.OpenQueue: server did not open queue %v: %v", def.Name, err)
+	}
+
+	log.WithFields(log.Fields{
+		"event": "matchbot.OpenQueue",
+		"queue": def.Name,
+	}).Info("queue open")
+	return nil
+}
+
+// hostQueue sets up a queue locally and starts it, for the current session:
+// it stops with the session, even if whoever closes queues at the end of the
+// session never hears of it.
+func (m *Matchbot) hostQueue(def *queue.Definition) (*queue.Queue, error) {
+	q, err := queue.NewQueue(def, m.matches, queue.Options{
+		Users: m.client.User,
+		Clock: m.clock,
+		Store: m.store,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate queue %v: %v", def.Name, err)
+	}
+
+	var session context.Context
+	if !m.do(func() { session = m.session }) {
+		q.Close()
+		return nil, fmt.Errorf("not hosting queue %v: the matchbot is shutting down", def.Name)
+	}
+
+	err = q.Start(session)
+	if err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to start queue %v: %v", def.Name, err)
+	}
+
+	exists, ended := false, false
+	ok := m.do(func() {
+		// the session ended while the queue was starting
+		if m.session != session {
+			ended = true
+			return
+		}
+
+		_, exists = m.queues[def.Name]
+		if !exists {
+			m.queues[def.Name] = q
+		}
+	})
+
+	switch {
+	case !ok:
+		q.Close()
+		return nil, fmt.Errorf("not hosting queue %v: the matchbot is shutting down", def.Name)
+	case ended:
+		q.Close()
+		return nil, fmt.Errorf("not hosting queue %v: the session ended", def.Name)
+	case exists:
+		q.Close()
+		return nil, fmt.Errorf("queue %v is already open", def.Name)
+	}
+	return q, nil
+}
+
+// UpdateQueue replaces the definition of an open queue, both on the server
+// and in the queue's Lua script. players stay queued, unless the new
+// definition runs a different script: then the queue is closed and reopened.
+func (m *Matchbot) UpdateQueue(def *queue.Definition) error {
+	var q *queue.Queue
+	m.do(func() {
+		q = m.queues[def.Name]
+	})
+
+	var current *queue.Definition
+	if q != nil {
+		current = q.Definition()
+	}
+	if current == nil {
+		return fmt.Errorf("matchbot.UpdateQueue: queue %v is not open", def.Name)
+	}
+
+	// everything q.Update would refuse is refused here, before the server
+	// hears of a definition we won't run
+	if current.Name != def.Name {
+		return fmt.Errorf("matchbot.UpdateQueue: cannot rename queue %v to %v", current.Name, def.Name)
+	}
+
+	_, err := def.Interval()
+	if err != nil {
+		return fmt.Errorf("matchbot.UpdateQueue: queue %v: %v", def.Name, err)
+	}
+
+	if current.ScriptFile() != def.ScriptFile() {
+		// a queue can't swap scripts in place: start it over
+		err = m.CloseQueue(def.Name, "this queue is restarting with new matchmaking rules, please rejoin")
+		if err != nil {
+			return fmt.Errorf("matchbot.UpdateQueue: could not restart queue %v: %v", def.Name, err)
+		}
+
+		err = m.OpenQueue(def)
+		if err != nil {
+			return fmt.Errorf("matchbot.UpdateQueue: could not restart queue %v: %v", def.Name, err)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	err = m.client.UpdateQueue(ctx, &def.QueueDefinition)
+	if err != nil {
+		return fmt.Errorf("matchbot.UpdateQueue: could not update queue %v on the server: %v", def.Name, err)
+	}
+
+	err = q.Update(def)
+	if err != nil {
+		return fmt.Errorf("matchbot.UpdateQueue: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"event": "matchbot.UpdateQueue",
+		"queue": def.Name,
+	}).Info("queue updated")
+	return nil
+}
+
+// CloseQueue stops hosting a queue. everyone waiting in it is released, and
+// told why. matches already made from it carry on.
+func (m *Matchbot) CloseQueue(name string, reason string) error {
+	var q *queue.Queue
+	released := []string{}
+	m.do(func() {
+		q = m.queues[name]
+		if q == nil {
+			return
+		}
+
+		delete(m.queues, name)
+		for player, playerQueue := range m.players {
+			if playerQueue == q {
+				delete(m.players, player)
+				released = append(released, player)
+			}
+		}
+	})
+
+	if q == nil {
+		return fmt.Errorf("matchbot.CloseQueue: queue %v is not open", name)
+	}
+
+	q.Close()
+
+	log.WithFields(log.Fields{
+		"event":    "matchbot.CloseQueue",
+		"queue":    name,
+		"reason":   reason,
+		"released": released,
+	}).Info("queue closed")
+
+	// the server tells everyone in the queue that it closed; this is for the
+	// players we had, in case the server's idea of who was queued differs
+	for _, player := range released {
+		err := m.client.SayPrivate(player, fmt.Sprintf("you have left queue %v, because it closed: %v", name, reason))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "matchbot.CloseQueue",
+				"queue": name,
+				"user":  player,
+				"error": err,
+			}).Warn("could not tell a released player why")
+		}
+	}
+
+	err := m.client.CloseQueue(name, reason)
+	if err != nil {
+		return fmt.Errorf("matchbot.CloseQueue: could not close queue %v on the server: %v", name, err)
+	}
+	return nil
+}
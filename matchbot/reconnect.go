@@ -0,0 +1,140 @@
+package matchbot
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"math/rand"
+	"time"
+)
+
+// Backoff produces the delays between attempts to reach the lobby server:
+// exponential growth from Min to Max, with some jitter so a fleet of bots
+// doesn't reconnect in lockstep after a server restart.
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Factor float64
+	// fraction of each delay which is randomized, from 0 to 1
+	Jitter float64
+
+	attempt int
+}
+
+// DefaultBackoff is used if the Config leaves Reconnect empty.
+var DefaultBackoff = Backoff{
+	Min:    1 * time.Second,
+	Max:    2 * time.Minute,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+// Next is the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	delay := float64(b.Min)
+	for i := 0; i < b.attempt && delay < float64(b.Max); i++ {
+		delay *= b.Factor
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	b.attempt++
+
+	// spread the delay evenly over [delay * (1 - Jitter), delay]
+	delay -= delay * b.Jitter * rand.Float64()
+	return time.Duration(delay)
+}
+
+// Reset starts the delays from Min again.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// ConnectionEvent reports the outcome of one attempt to reach the lobby server.
+type ConnectionEvent struct {
+	Time    time.Time
+	Attempt int
+	// Connected is true when a session starts; Err is set when an attempt
+	// fails or a session ends
+	Connected bool
+	Err       error
+	// how long until the next attempt, if there will be one
+	Delay time.Duration
+}
+
+// OnConnection registers an observer for connection attempts and their
+// outcomes. observers are called synchronously from the reconnect loop, so
+// they should be quick.
+func (m *Matchbot) OnConnection(observer func(ConnectionEvent)) {
+	m.observersMut.Lock()
+	defer m.observersMut.Unlock()
+	m.observers = append(m.observers, observer)
+}
+
+func (m *Matchbot) notifyConnection(event ConnectionEvent) {
+	event.Time = m.clock.Now()
+
+	m.observersMut.Lock()
+	observers := make([]func(ConnectionEvent), len(m.observers))
+	copy(observers, m.observers)
+	m.observersMut.Unlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// ConnectionStatus sums up the connection events so far.
+type ConnectionStatus struct {
+	// attempts to connect since the last login, including the current
+	// session's
+	Attempts int `json:"attempts"`
+	// connections made, whether or not the login went through
+	Sessions      int       `json:"sessions"`
+	LastConnected time.Time `json:"lastConnected"`
+	// why the last attempt failed or the last session ended
+	LastError string `json:"lastError,omitempty"`
+	// when the next attempt is due; zero while connected
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// recordConnection keeps m.connection up to date; it observes every
+// connection event from the start.
+func (m *Matchbot) recordConnection(event ConnectionEvent) {
+	m.connectionMut.Lock()
+	defer m.connectionMut.Unlock()
+
+	m.connection.Attempts = event.Attempt
+	if event.Connected {
+		m.connection.Sessions++
+		m.connection.LastConnected = event.Time
+		m.connection.NextAttempt = time.Time{}
+	}
+	if event.Err != nil {
+		m.connection.LastError = event.Err.Error()
+		m.connection.NextAttempt = event.Time.Add(event.Delay)
+	}
+}
+
+// resetSession forgets everything the previous connection knew: the server
+// has forgotten our queues and their players too. queues are reopened from
+// their definitions once the new session logs in.
+func (m *Matchbot) resetSession() {
+	var queues map[string]*queue.Queue
+	m.do(func() {
+		m.endSession()
+		m.session, m.endSession = context.WithCancel(context.Background())
+
+		queues = m.queues
+		m.queues = make(map[string]*queue.Queue)
+		m.players = make(map[string]*queue.Queue)
+	})
+
+	for name, q := range queues {
+		log.WithFields(log.Fields{
+			"event": "matchbot.resetSession",
+			"queue": name,
+		}).Debug("dropping queue from previous session")
+		q.Close()
+	}
+}
@@ -0,0 +1,129 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Report is what happened in a simulation.
+type Report struct {
+	Duration time.Duration
+
+	Arrived int
+	Matched int
+	// gave up before being matched
+	Left int
+	// still in the queue at the end
+	StillWaiting int
+	// waited longer than Config.StarveAfter, however it ended
+	Starved int
+
+	// seconds matched players waited
+	Waits Summary
+	// matchmaking.Quality of each match: 1 is an even game
+	Quality Summary
+	// rating gap between the best and worst player in each match
+	RatingSpread Summary
+
+	Matches []Match
+
+	waits   []float64
+	quality []float64
+	spread  []float64
+}
+
+// Match is a match the script made.
+type Match struct {
+	Id uint64
+	// simulated time since the start
+	At           time.Duration
+	Map          string
+	MapReason    string
+	Players      []Player
+	Quality      float64
+	RatingSpread float64
+}
+
+// Player is a simulated player, as matched.
+type Player struct {
+	Name   string
+	Rating float64
+	Waited time.Duration
+}
+
+// Summary describes a distribution of values.
+type Summary struct {
+	Count  int
+	Min    float64
+	Mean   float64
+	Median float64
+	P90    float64
+	Max    float64
+}
+
+func summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+
+	return Summary{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Mean:   sum / float64(len(sorted)),
+		Median: percentile(sorted, 0.5),
+		P90:    percentile(sorted, 0.9),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// percentile of already sorted values, nearest rank
+func percentile(sorted []float64, p float64) float64 {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s Summary) String() string {
+	if s.Count == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("min %.2f  median %.2f  mean %.2f  p90 %.2f  max %.2f", s.Min, s.Median, s.Mean, s.P90, s.Max)
+}
+
+// Write prints the report for people. with verbose, every match is listed.
+func (r *Report) Write(out io.Writer, verbose bool) {
+	fmt.Fprintf(out, "simulated %v\n", r.Duration)
+	fmt.Fprintf(out, "players:        %v arrived, %v matched, %v gave up, %v still waiting\n", r.Arrived, r.Matched, r.Left, r.StillWaiting)
+	fmt.Fprintf(out, "starved:        %v\n", r.Starved)
+	fmt.Fprintf(out, "matches:        %v\n", len(r.Matches))
+	fmt.Fprintf(out, "wait (s):       %v\n", r.Waits)
+	fmt.Fprintf(out, "quality:        %v\n", r.Quality)
+	fmt.Fprintf(out, "rating spread:  %v\n", r.RatingSpread)
+
+	if !verbose {
+		return
+	}
+
+	fmt.Fprintln(out)
+	for _, m := range r.Matches {
+		fmt.Fprintf(out, "%8v  match %v on %v, quality %.2f, spread %.0f\n", m.At, m.Id, m.Map, m.Quality, m.RatingSpread)
+		for _, p := range m.Players {
+			fmt.Fprintf(out, "          %-10s rating %6.0f  waited %v\n", p.Name, p.Rating, p.Waited)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Package simulator runs a queue's Lua script against made-up players, on
+// simulated time, so script authors can see how it matches without a lobby
+// server or any real users.
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/clock"
+	"github.com/kanatohodets/go-match/matchbot/matchmaking"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Config describes the players who show up, and for how long.
+type Config struct {
+	Queue *queue.Definition
+
+	// simulated time to run for
+	Duration time.Duration
+	// players arrive at random, this many per minute on average
+	ArrivalsPerMinute float64
+	// players who aren't matched give up after this long on average; zero
+	// means they wait forever
+	Patience time.Duration
+	// ratings are normally distributed
+	RatingMean   float64
+	RatingStdDev float64
+	// a player who waits longer than this, matched or not, is starved;
+	// DefaultStarveAfter if zero
+	StarveAfter time.Duration
+
+	Seed int64
+}
+
+// DefaultStarveAfter is how long a wait is too long, unless configured.
+const DefaultStarveAfter = 5 * time.Minute
+
+// biggest lambda poisson draws in one go
+const poissonChunk = 30
+
+// simulated time starts here; any fixed point would do
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type player struct {
+	name    string
+	rating  float64
+	joined  time.Time
+	giveUp  time.Time
+	patient bool
+}
+
+type simulation struct {
+	config Config
+	rng    *rand.Rand
+	clock  *clock.Fake
+	now    time.Time
+	// simulated time between calls to queue.Update: the queue's own
+	// updateInterval, as a real queue would use
+	step time.Duration
+
+	q       *queue.Queue
+	matches chan *queue.Match
+
+	// owned by the simulation's goroutine: the queue only looks players up
+	// while the simulation waits on it
+	players map[string]*player
+	waiting map[string]*player
+	arrived int
+
+	report *Report
+}
+
+// Run simulates config.Duration of the queue, and reports on how it went.
+func Run(config Config) (*Report, error) {
+	if config.Queue == nil {
+		return nil, fmt.Errorf("simulator.Run: no queue to simulate")
+	}
+
+	if config.StarveAfter == 0 {
+		config.StarveAfter = DefaultStarveAfter
+	}
+
+	step, err := config.Queue.Interval()
+	if err != nil {
+		return nil, fmt.Errorf("simulator.Run: queue %v: %v", config.Queue.Name, err)
+	}
+
+	s := &simulation{
+		config:  config,
+		rng:     rand.New(rand.NewSource(config.Seed)),
+		clock:   clock.NewFake(epoch),
+		now:     epoch,
+		step:    step,
+		matches: make(chan *queue.Match, 256),
+		players: map[string]*player{},
+		waiting: map[string]*player{},
+		report:  &Report{Duration: config.Duration},
+	}
+
+	q, err := queue.NewQueue(config.Queue, s.matches, queue.Options{
+		Users:   s.user,
+		Ratings: s.rating,
+		Clock:   s.clock,
+		Manual:  true,
+		Seed:    config.Seed,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("simulator.Run: %v", err)
+	}
+	defer q.Close()
+	s.q = q
+
+	err = q.Start(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("simulator.Run: %v", err)
+	}
+
+	for s.now.Sub(epoch) < config.Duration {
+		next := s.now.Add(s.step)
+
+		// players turn up between updates, each joining when they arrive
+		for _, at := range s.arrivals(next) {
+			err := s.advance(at)
+			if err != nil {
+				return nil, err
+			}
+
+			err = s.arrive()
+			if err != nil {
+				return nil, err
+			}
+
+			err = s.collect()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := s.advance(next)
+		if err != nil {
+			return nil, err
+		}
+
+		err = q.Tick()
+		if err != nil {
+			return nil, fmt.Errorf("simulator.Run: %v", err)
+		}
+
+		err = s.collect()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.finish()
+	return s.report, nil
+}
+
+func (s *simulation) user(name string) (protocol.User, bool) {
+	_, ok := s.players[name]
+	if !ok {
+		return protocol.User{}, false
+	}
+	return protocol.User{Name: name}, true
+}
+
+func (s *simulation) rating(name string) (float64, bool) {
+	p, ok := s.players[name]
+	if !ok {
+		return 0, false
+	}
+	return p.rating, true
+}
+
+// arrivals draws when players turn up between now and the next update, in
+// order.
+func (s *simulation) arrivals(next time.Time) []time.Time {
+	n := poisson(s.rng, s.config.ArrivalsPerMinute*s.step.Minutes())
+	times := make([]time.Time, n)
+	for i := range times {
+		// (now, next]: nobody arrives twice at the same update
+		times[i] = next.Add(-time.Duration(s.rng.Float64() * float64(s.step)))
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	return times
+}
+
+// arrive adds a new player, now.
+func (s *simulation) arrive() error {
+	s.arrived++
+	p := &player{
+		name:   fmt.Sprintf("sim%d", s.arrived),
+		rating: s.config.RatingMean + s.rng.NormFloat64()*s.config.RatingStdDev,
+		joined: s.now,
+	}
+
+	if s.config.Patience > 0 {
+		p.patient = true
+		patience := time.Duration(s.rng.ExpFloat64() * float64(s.config.Patience))
+		p.giveUp = s.now.Add(patience)
+	}
+
+	s.players[p.name] = p
+	s.waiting[p.name] = p
+	s.report.Arrived++
+
+	err := s.q.AddPlayer(p.name, queue.MapVote{})
+	if err != nil {
+		return fmt.Errorf("simulator.arrive: %v", err)
+	}
+	return nil
+}
+
+// advance moves simulated time on to t. players who run out of patience on
+// the way leave when they do, in order.
+func (s *simulation) advance(t time.Time) error {
+	leaving := []*player{}
+	for _, p := range s.waiting {
+		if p.patient && !p.giveUp.After(t) {
+			leaving = append(leaving, p)
+		}
+	}
+	sort.Slice(leaving, func(i, j int) bool {
+		if !leaving[i].giveUp.Equal(leaving[j].giveUp) {
+			return leaving[i].giveUp.Before(leaving[j].giveUp)
+		}
+		return leaving[i].name < leaving[j].name
+	})
+
+	for _, p := range leaving {
+		// someone matched since the last one left is no longer waiting
+		if _, ok := s.waiting[p.name]; !ok {
+			continue
+		}
+
+		s.moveTo(p.giveUp)
+		delete(s.waiting, p.name)
+		s.report.Left++
+		if s.now.Sub(p.joined) > s.config.StarveAfter {
+			s.report.Starved++
+		}
+
+		err := s.q.RemovePlayer(p.name)
+		if err != nil {
+			return fmt.Errorf("simulator.advance: %v", err)
+		}
+
+		err = s.collect()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.moveTo(t)
+	return nil
+}
+
+// moveTo sets the clock forward to t, if it isn't there already.
+func (s *simulation) moveTo(t time.Time) {
+	if t.After(s.now) {
+		s.clock.Advance(t.Sub(s.now))
+		s.now = s.clock.Now()
+	}
+}
+
+// collect records the matches made since it was last called. every match
+// starts, and is over, straight away: its players are done with the queue.
+func (s *simulation) collect() error {
+	for {
+		var match *queue.Match
+		select {
+		case match = <-s.matches:
+		default:
+			return nil
+		}
+
+		record := Match{
+			Id:        match.Id,
+			At:        s.now.Sub(epoch),
+			Map:       match.Map,
+			MapReason: match.MapReason,
+		}
+
+		teams := map[int][]matchmaking.Candidate{}
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, qp := range match.Players {
+			p, ok := s.players[qp.Name]
+			if !ok {
+				return fmt.Errorf("simulator.collect: match %v has unknown player %v", match.Id, qp.Name)
+			}
+
+			waited := s.now.Sub(p.joined)
+			record.Players = append(record.Players, Player{
+				Name:   p.name,
+				Rating: p.rating,
+				Waited: waited,
+			})
+
+			delete(s.waiting, p.name)
+			s.report.Matched++
+			s.report.waits = append(s.report.waits, waited.Seconds())
+			if waited > s.config.StarveAfter {
+				s.report.Starved++
+			}
+
+			if qp.Game != nil && !qp.Game.Spectator {
+				teams[qp.Game.AllyTeam] = append(teams[qp.Game.AllyTeam], matchmaking.Candidate{
+					Name:   p.name,
+					Rating: p.rating,
+				})
+			}
+			low = math.Min(low, p.rating)
+			high = math.Max(high, p.rating)
+		}
+
+		err := s.q.MatchStarted(match)
+		if err != nil {
+			return fmt.Errorf("simulator.collect: %v", err)
+		}
+
+		err = s.q.MatchEnded(match, queue.MatchResult{})
+		if err != nil {
+			return fmt.Errorf("simulator.collect: %v", err)
+		}
+
+		// in order, so the same seed always gives the same quality
+		allies := []int{}
+		for ally := range teams {
+			allies = append(allies, ally)
+		}
+		sort.Ints(allies)
+
+		allyTeams := [][]matchmaking.Candidate{}
+		for _, ally := range allies {
+			allyTeams = append(allyTeams, teams[ally])
+		}
+		record.Quality = matchmaking.Quality(allyTeams)
+		record.RatingSpread = high - low
+
+		s.report.quality = append(s.report.quality, record.Quality)
+		s.report.spread = append(s.report.spread, record.RatingSpread)
+		s.report.Matches = append(s.report.Matches, record)
+	}
+}
+
+// finish accounts for the players still waiting when time runs out.
+func (s *simulation) finish() {
+	for _, p := range s.waiting {
+		s.report.StillWaiting++
+		if s.now.Sub(p.joined) > s.config.StarveAfter {
+			s.report.Starved++
+		}
+	}
+
+	s.report.Waits = summarize(s.report.waits)
+	s.report.Quality = summarize(s.report.quality)
+	s.report.RatingSpread = summarize(s.report.spread)
+}
+
+// poisson draws how many events happen in an interval where lambda are
+// expected (Knuth's method). a long step can expect enough arrivals for
+// math.Exp(-lambda) to underflow, so big lambdas are drawn in pieces: the
+// sum of Poisson draws is Poisson too.
+func poisson(rng *rand.Rand, lambda float64) int {
+	if lambda <= 0 {
+		return 0
+	}
+
+	n := 0
+	for lambda > poissonChunk {
+		n += poisson(rng, poissonChunk)
+		lambda -= poissonChunk
+	}
+
+	limit := math.Exp(-lambda)
+	p := rng.Float64()
+	for p > limit {
+		n++
+		p *= rng.Float64()
+	}
+	return n
+}
@@ -0,0 +1,224 @@
+package simulator
+
+import (
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/matchbot/queue/queuetest"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pairs whoever is waiting, but only from queue.Update: how long players
+// wait depends on the queue's updateInterval
+const updatePairScript = `
+local waiting = {}
+
+function queue.PlayerJoined(name)
+	table.insert(waiting, name)
+end
+
+function queue.PlayerLeft(name)
+	for i, waiter in ipairs(waiting) do
+		if waiter == name then
+			table.remove(waiting, i)
+			return
+		end
+	end
+end
+
+function queue.TryMatch() end
+
+function queue.Update()
+	while #waiting >= 2 do
+		local first = table.remove(waiting, 1)
+		local second = table.remove(waiting, 1)
+		queue.NewMatch({
+			map = "DeltaSiegeDry",
+			game = "Balanced Annihilation V9.46",
+			players = {
+				{ name = first, team = 0, ally = 0 },
+				{ name = second, team = 1, ally = 1 },
+			}
+		})
+	end
+end
+`
+
+func definition(t *testing.T, interval string, source string) *queue.Definition {
+	return &queue.Definition{
+		QueueDefinition: queuetest.Queue("1v1"),
+		LuaFile:         queuetest.Script(t, source),
+		UpdateInterval:  interval,
+	}
+}
+
+func run(t *testing.T, config Config) *Report {
+	report, err := Run(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestPoisson(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, lambda := range []float64{0, 0.05, 2.5, 80, 1000} {
+		const draws = 20000
+		sum, squares := 0.0, 0.0
+		for i := 0; i < draws; i++ {
+			n := float64(poisson(rng, lambda))
+			sum += n
+			squares += n * n
+		}
+
+		mean := sum / draws
+		variance := squares/draws - mean*mean
+		// both the mean and the variance of a Poisson distribution are lambda
+		tolerance := 0.05*lambda + 0.01
+		if math.Abs(mean-lambda) > tolerance || math.Abs(variance-lambda) > 2*tolerance {
+			t.Errorf("poisson(%v): mean %.3f, variance %.3f", lambda, mean, variance)
+		}
+	}
+}
+
+// arrivals per minute don't depend on how often the queue updates
+func TestArrivals(t *testing.T) {
+	for _, interval := range []string{"1s", "1m", "10m"} {
+		report := run(t, Config{
+			Queue:             definition(t, interval, queuetest.IdleScript),
+			Duration:          2 * time.Hour,
+			ArrivalsPerMinute: 30,
+			Seed:              1,
+		})
+
+		want := 30.0 * 120
+		if math.Abs(float64(report.Arrived)-want) > 0.05*want {
+			t.Errorf("updating every %v: %v players arrived, want about %v", interval, report.Arrived, want)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	slow := run(t, Config{
+		Queue:             definition(t, "1m", updatePairScript),
+		Duration:          time.Hour,
+		ArrivalsPerMinute: 4,
+		Seed:              1,
+	})
+
+	if len(slow.Matches) == 0 {
+		t.Fatal("no matches")
+	}
+
+	// players join whenever they arrive, and are matched on the queue's
+	// updates
+	for _, match := range slow.Matches {
+		if match.At%time.Minute != 0 {
+			t.Errorf("match %v made at %v, between updates", match.Id, match.At)
+		}
+		for _, p := range match.Players {
+			if p.Waited <= 0 || p.Waited > match.At {
+				t.Errorf("%v waited %v for match %v at %v", p.Name, p.Waited, match.Id, match.At)
+			}
+		}
+	}
+
+	fast := run(t, Config{
+		Queue:             definition(t, "1s", updatePairScript),
+		Duration:          time.Hour,
+		ArrivalsPerMinute: 4,
+		Seed:              1,
+	})
+
+	// half an update, on average, and more for whoever is left over
+	if slow.Waits.Mean < 30 || fast.Waits.Mean >= slow.Waits.Mean {
+		t.Errorf("updating every second, players waited %.1fs on average; every minute, %.1fs", fast.Waits.Mean, slow.Waits.Mean)
+	}
+
+	_, err := Run(Config{
+		Queue:    definition(t, "soon", updatePairScript),
+		Duration: time.Hour,
+	})
+	if err == nil {
+		t.Errorf("simulated a queue with an updateInterval of %q", "soon")
+	}
+}
+
+func TestReport(t *testing.T) {
+	config := Config{
+		Queue:             definition(t, "30s", updatePairScript),
+		Duration:          2 * time.Hour,
+		ArrivalsPerMinute: 3,
+		Patience:          time.Minute,
+		RatingMean:        1500,
+		RatingStdDev:      300,
+		StarveAfter:       45 * time.Second,
+		Seed:              7,
+	}
+	report := run(t, config)
+
+	if report.Arrived != report.Matched+report.Left+report.StillWaiting {
+		t.Errorf("%v arrived, but %v matched, %v gave up and %v are still waiting", report.Arrived, report.Matched, report.Left, report.StillWaiting)
+	}
+
+	if report.Left == 0 {
+		t.Errorf("nobody gave up")
+	}
+
+	waits := []float64{}
+	starved := 0
+	for _, match := range report.Matches {
+		for _, p := range match.Players {
+			waits = append(waits, p.Waited.Seconds())
+			if p.Waited > config.StarveAfter {
+				starved++
+			}
+		}
+	}
+
+	if report.Matched != len(waits) || report.Waits != summarize(waits) {
+		t.Errorf("waits are %+v for %v matched players, want %+v for %v", report.Waits, report.Matched, summarize(waits), len(waits))
+	}
+
+	// matched players who waited too long are starved, and perhaps some of
+	// the others
+	if starved == 0 || report.Starved < starved || report.Starved > starved+report.Left+report.StillWaiting {
+		t.Errorf("%v starved, %v of them matched", report.Starved, starved)
+	}
+
+	if again := run(t, config); !reflect.DeepEqual(again, report) {
+		t.Errorf("the same seed gave a different report")
+	}
+
+	config.Seed = 8
+	if other := run(t, config); reflect.DeepEqual(other, report) {
+		t.Errorf("a different seed gave the same report")
+	}
+}
+
+// players still waiting at the end count as starved too
+func TestStillWaitingStarve(t *testing.T) {
+	config := Config{
+		Queue:             definition(t, "10m", queuetest.IdleScript),
+		Duration:          10 * time.Minute,
+		ArrivalsPerMinute: 2,
+		StarveAfter:       10 * time.Minute,
+		Seed:              1,
+	}
+
+	// by the first update, nobody has waited ten minutes
+	first := run(t, config)
+	if first.Starved != 0 || first.StillWaiting != first.Arrived || first.Arrived == 0 {
+		t.Fatalf("after one update: %+v", first)
+	}
+
+	// the same seed brings the same first arrivals, who by the second update
+	// have all waited over ten minutes; nobody after them has
+	config.Duration = 20 * time.Minute
+	second := run(t, config)
+	if second.Starved != first.Arrived || second.StillWaiting != second.Arrived {
+		t.Errorf("after two updates: %v starved of %v still waiting, want the %v first arrivals", second.Starved, second.StillWaiting, first.Arrived)
+	}
+}
@@ -0,0 +1,36 @@
+package matchbot
+
+// the matchbot's mutable state (queues, players and ready checks in flight)
+// belongs to the goroutine running run. everyone else hands it work through
+// do. that work is bookkeeping only: calls into queues (their Lua can be
+// slow) and sends to the lobby client (which wait when its outbound buffer is
+// full) happen before or after do, never inside it.
+
+func (m *Matchbot) run() {
+	for {
+		select {
+		case action := <-m.actions:
+			action()
+		case <-m.shutdown:
+			return
+		}
+	}
+}
+
+// do runs fn on the state goroutine, and waits for it to finish. it returns
+// false, without running fn, once the matchbot is shutting down.
+func (m *Matchbot) do(fn func()) bool {
+	done := make(chan struct{})
+	action := func() {
+		fn()
+		close(done)
+	}
+
+	select {
+	case m.actions <- action:
+		<-done
+		return true
+	case <-m.shutdown:
+		return false
+	}
+}
@@ -0,0 +1,195 @@
+// Package store keeps the matchbot's state across restarts: small values,
+// by key, in namespaces (one per queue, say).
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store is a namespaced key/value store. it is safe for concurrent use.
+type Store interface {
+	Get(namespace string, key string) (value string, ok bool, err error)
+	// Set stores value under key, replacing what was there
+	Set(namespace string, key string, value string) error
+	Delete(namespace string, key string) error
+	// Keys lists a namespace's keys, sorted
+	Keys(namespace string) ([]string, error)
+}
+
+// Memory is a Store which forgets everything when the process exits: for
+// simulations, tests, and running without a state file.
+type Memory struct {
+	mut  sync.RWMutex
+	data map[string]map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{data: map[string]map[string]string{}}
+}
+
+func (m *Memory) Get(namespace string, key string) (string, bool, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	value, ok := m.data[namespace][key]
+	return value, ok, nil
+}
+
+func (m *Memory) Set(namespace string, key string, value string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.set(namespace, key, value)
+	return nil
+}
+
+func (m *Memory) Delete(namespace string, key string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.delete(namespace, key)
+	return nil
+}
+
+func (m *Memory) Keys(namespace string) ([]string, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	keys := []string{}
+	for key := range m.data[namespace] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) set(namespace string, key string, value string) {
+	ns, ok := m.data[namespace]
+	if !ok {
+		ns = map[string]string{}
+		m.data[namespace] = ns
+	}
+	ns[key] = value
+}
+
+func (m *Memory) delete(namespace string, key string) {
+	ns, ok := m.data[namespace]
+	if !ok {
+		return
+	}
+
+	delete(ns, key)
+	if len(ns) == 0 {
+		delete(m.data, namespace)
+	}
+}
+
+// File is a Store kept in a JSON file. every change rewrites the whole file,
+// so it suits a modest amount of state which changes now and then, not a
+// firehose.
+type File struct {
+	Memory
+	path string
+}
+
+// NewFile opens the store in path, which is created on the first change if
+// it doesn't exist yet.
+func NewFile(path string) (*File, error) {
+	f := &File{
+		Memory: Memory{data: map[string]map[string]string{}},
+		path:   path,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("store.NewFile: could not read %v: %v", path, err)
+	}
+
+	err = json.Unmarshal(b, &f.data)
+	if err != nil {
+		return nil, fmt.Errorf("store.NewFile: could not decode %v: %v", path, err)
+	}
+
+	if f.data == nil {
+		f.data = map[string]map[string]string{}
+	}
+	return f, nil
+}
+
+func (f *File) Set(namespace string, key string, value string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	old, existed := f.data[namespace][key]
+	f.set(namespace, key, value)
+
+	err := f.save()
+	if err != nil {
+		// keep memory and disk in agreement
+		if existed {
+			f.set(namespace, key, old)
+		} else {
+			f.delete(namespace, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *File) Delete(namespace string, key string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	old, existed := f.data[namespace][key]
+	if !existed {
+		return nil
+	}
+	f.delete(namespace, key)
+
+	err := f.save()
+	if err != nil {
+		f.set(namespace, key, old)
+		return err
+	}
+	return nil
+}
+
+// save writes the store out: to a temporary file first, renamed into place,
+// so a crash mid-write leaves the previous version intact.
+func (f *File) save() error {
+	b, err := json.MarshalIndent(f.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("store.save: could not encode state: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("store.save: could not create temporary file: %v", err)
+	}
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("store.save: could not write %v: %v", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), f.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("store.save: could not replace %v: %v", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"github.com/kanatohodets/go-match/spring/game"
+	"os"
+)
+
+// renderScript prints the startscript a queue would produce for a match of
+// made-up players, split evenly across two allyteams.
+func renderScript(args []string) int {
+	flags := flag.NewFlagSet("render-script", flag.ExitOnError)
+	queuesFile := flags.String("queues", "example/queue.json", "queues file")
+	name := flags.String("queue", "", "queue to render a match for; the first in the file if empty")
+	players := flags.Int("players", 2, "players in the match")
+	mapName := flags.String("map", "", "map; the queue's first if empty")
+	gameName := flags.String("game", "", "game; the queue's first if empty")
+	flags.Parse(args)
+
+	def, err := findQueue(*queuesFile, *name)
+	if err != nil {
+		printQueuesError(*queuesFile, err)
+		return 1
+	}
+
+	match := &queue.Match{
+		QueueName:     def.Name,
+		Map:           *mapName,
+		MapReason:     "render-script",
+		Game:          *gameName,
+		EngineVersion: def.EngineVersions[0],
+		Script:        def.Script,
+	}
+
+	if match.Map == "" {
+		match.Map = def.MapNames[0]
+	}
+
+	if match.Game == "" {
+		match.Game = def.GameNames[0]
+	}
+
+	for i := 0; i < *players; i++ {
+		p := queue.NewPlayer(fmt.Sprintf("Player%d", i+1))
+		p.SetMatched(&queue.Seat{
+			Team:     i,
+			AllyTeam: i % 2,
+		})
+		match.Players = append(match.Players, p)
+	}
+
+	err = game.New(match, nil, nil).RenderScript(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
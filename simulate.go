@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/simulator"
+	"os"
+	"time"
+)
+
+// simulate runs a queue's script against made-up players on simulated time,
+// with no lobby, and reports on the matches it makes.
+func simulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	queuesFile := flags.String("queues", "example/queue.json", "queues file")
+	name := flags.String("queue", "", "queue to simulate; the first in the file if empty")
+	duration := flags.Duration("duration", time.Hour, "simulated time to run for")
+	arrivals := flags.Float64("arrivals", 2, "players arriving per minute, on average")
+	patience := flags.Duration("patience", 10*time.Minute, "how long unmatched players wait before leaving, on average; 0 for forever")
+	mean := flags.Float64("rating-mean", 1500, "mean player rating")
+	stddev := flags.Float64("rating-stddev", 300, "standard deviation of player ratings")
+	starve := flags.Duration("starve-after", simulator.DefaultStarveAfter, "waits longer than this count as starvation")
+	seed := flags.Int64("seed", 1, "random seed, for repeatable runs")
+	verbose := flags.Bool("v", false, "list every match")
+	flags.Parse(args)
+
+	// the report is the output: only scripts' warnings and errors get
+	// through alongside it
+	log.SetLevel(log.WarnLevel)
+
+	def, err := findQueue(*queuesFile, *name)
+	if err != nil {
+		printQueuesError(*queuesFile, err)
+		return 1
+	}
+
+	report, err := simulator.Run(simulator.Config{
+		Queue:             def,
+		Duration:          *duration,
+		ArrivalsPerMinute: *arrivals,
+		Patience:          *patience,
+		RatingMean:        *mean,
+		RatingStdDev:      *stddev,
+		StarveAfter:       *starve,
+		Seed:              *seed,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	report.Write(os.Stdout, *verbose)
+	return 0
+}
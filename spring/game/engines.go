@@ -0,0 +1,40 @@
+package game
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Engines maps an engine version, as named in queue definitions (e.g. "101"),
+// to the spring-dedicated binary that runs it. versions are installed side by
+// side, each with its own binary.
+type Engines map[string]string
+
+// Path returns the spring-dedicated binary for version, after checking that
+// it is actually installed and executable.
+func (e Engines) Path(version string) (string, error) {
+	binary, ok := e[version]
+	if !ok {
+		return "", fmt.Errorf("game.Engines: no spring-dedicated configured for engine version %q", version)
+	}
+
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", fmt.Errorf("game.Engines: spring-dedicated for engine version %q is not installed: %v", version, err)
+	}
+
+	return path, nil
+}
+
+// Missing lists the versions which can't be run, either because they aren't
+// configured or because their binary isn't installed.
+func (e Engines) Missing(versions []string) []string {
+	missing := []string{}
+	for _, version := range versions {
+		_, err := e.Path(version)
+		if err != nil {
+			missing = append(missing, version)
+		}
+	}
+	return missing
+}
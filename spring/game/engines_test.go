@@ -0,0 +1,90 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEngines has 103.0 installed; 104.0's binary isn't executable, 105.0's
+// doesn't exist, and anything else isn't configured at all.
+func testEngines(t *testing.T) (Engines, string) {
+	dir := t.TempDir()
+	installed := filepath.Join(dir, "spring-dedicated-103")
+	err := os.WriteFile(installed, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := filepath.Join(dir, "spring-dedicated-104")
+	err = os.WriteFile(broken, []byte("#!/bin/sh\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Engines{
+		"103.0": installed,
+		"104.0": broken,
+		"105.0": filepath.Join(dir, "spring-dedicated-105"),
+	}, installed
+}
+
+func TestEnginesPath(t *testing.T) {
+	engines, installed := testEngines(t)
+	cases := []struct {
+		version string
+		path    string
+		problem string
+	}{
+		{"103.0", installed, ""},
+		{"104.0", "", "not installed"},
+		{"105.0", "", "not installed"},
+		{"101.0", "", "no spring-dedicated configured"},
+	}
+
+	for _, c := range cases {
+		path, err := engines.Path(c.version)
+		if c.problem == "" {
+			if err != nil || path != c.path {
+				t.Errorf("%v: got %q, %v; want %q", c.version, path, err, c.path)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%v: got %q, %v; want an error mentioning %q", c.version, path, err, c.problem)
+		}
+	}
+}
+
+func TestEnginesMissing(t *testing.T) {
+	engines, _ := testEngines(t)
+
+	missing := engines.Missing([]string{"101.0", "103.0", "104.0", "105.0"})
+	if want := []string{"101.0", "104.0", "105.0"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing %v, want %v", missing, want)
+	}
+
+	if missing := engines.Missing([]string{"103.0"}); len(missing) != 0 {
+		t.Errorf("103.0 is installed, but reported missing: %v", missing)
+	}
+}
+
+// a match on an engine version the matchbot wasn't given fails to start,
+// before anything is written or run
+func TestStartUnconfiguredEngine(t *testing.T) {
+	engines, _ := testEngines(t)
+	g := testGame("bob", nil)
+	g.Engines = engines
+	g.Match.EngineVersion = "101.0"
+
+	err := g.Start()
+	if err == nil || !strings.Contains(err.Error(), `no spring-dedicated configured for engine version "101.0"`) {
+		t.Errorf("want a start failure for the unconfigured engine, got %v", err)
+	}
+	if g.GameDir != "" {
+		t.Errorf("game dir %v was set up for a game which can't start", g.GameDir)
+	}
+}
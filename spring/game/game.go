@@ -2,17 +2,16 @@ package game
 
 import (
 	"bufio"
+	"crypto/rand"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/matchbot/clock"
 	"github.com/kanatohodets/go-match/matchbot/queue"
 	"io"
-	"math/rand"
 	"net"
 	"os"
 	"os/exec"
 	"sync"
-	"text/template"
-	"time"
 )
 
 // Game represents a single running spring-dedicated server instance
@@ -22,6 +21,8 @@ type Game struct {
 	Match   *queue.Match
 	GameDir string
 	Script  *startScript
+	Engines Engines
+	Clock   clock.Clock
 
 	cmd      *exec.Cmd
 	stdout   io.ReadCloser
@@ -29,9 +30,16 @@ type Game struct {
 	shutdown chan struct{}
 }
 
-func New(match *queue.Match) *Game {
+// New gets you a game for a match, not started yet. a nil clock means clock.Real.
+func New(match *queue.Match, engines Engines, gameClock clock.Clock) *Game {
+	if gameClock == nil {
+		gameClock = clock.Real
+	}
+
 	return &Game{
-		Match: match,
+		Match:   match,
+		Engines: engines,
+		Clock:   gameClock,
 	}
 }
 
@@ -46,14 +54,14 @@ func (g *Game) Kill() error {
 }
 
 func (g *Game) Start() error {
-	err := g.prepareScript()
+	spring, err := g.Engines.Path(g.Match.EngineVersion)
 	if err != nil {
-		return fmt.Errorf("game.Start: couldn't prepare startscript: %v", err)
+		return fmt.Errorf("game.Start: couldn't find spring-dedicated: %v", err)
 	}
 
-	spring, err := exec.LookPath("spring-dedicated")
+	err = g.prepareScript()
 	if err != nil {
-		return fmt.Errorf("game.Start: couldn't find spring-dedicated: %v", err)
+		return fmt.Errorf("game.Start: couldn't prepare startscript: %v", err)
 	}
 
 	wd, err := os.Getwd()
@@ -92,7 +100,8 @@ func (g *Game) Start() error {
 	return nil
 }
 
-func (g *Game) Wait() {
+// Wait blocks until spring-dedicated exits, and returns its error, if any.
+func (g *Game) Wait() error {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	stdoutScanner := bufio.NewScanner(g.stdout)
@@ -133,30 +142,75 @@ func (g *Game) Wait() {
 		}).Error("spring-dedicated exited with an error")
 	}
 
-	//TODO: game over, put players back in queue?
 	log.WithFields(log.Fields{
 		"event": "game.StartGame",
 	}).Info("game all done@!!!")
+	return err
 }
 
 func (g *Game) prepareScript() error {
-	port, err := openPort()
+	script, err := g.buildScript()
 	if err != nil {
 		return err
 	}
 
-	hostPort, err := openPort()
+	prefix := "games"
+	path := fmt.Sprintf("%s/%s/%d", prefix, g.Match.QueueName, g.Clock.Now().Unix())
+
+	g.GameDir = path
+
+	err = generateStartScript(path, script)
+	if err != nil {
+		return fmt.Errorf("game.PrepareScript: could not create startscript: %v", err)
+	}
+
+	g.Script = script
+
+	return nil
+}
+
+// RenderScript writes the startscript this game would start with, without
+// starting anything. every call picks new ports and passwords.
+func (g *Game) RenderScript(out io.Writer) error {
+	script, err := g.buildScript()
 	if err != nil {
 		return err
 	}
 
+	err = script.write(out)
+	if err != nil {
+		return fmt.Errorf("game.RenderScript: failed to write startscript: %v", err)
+	}
+	return nil
+}
+
+func (g *Game) buildScript() (*startScript, error) {
+	port, err := openPort()
+	if err != nil {
+		return nil, err
+	}
+
+	hostPort, err := openPort()
+	if err != nil {
+		return nil, err
+	}
+
+	startPosType := 1
+	if g.Match.Script.StartPosType != nil {
+		startPosType = *g.Match.Script.StartPosType
+	}
+
 	script := &startScript{
 		IP:           "127.0.0.1",
-		Engine:       "103.0",
+		Engine:       g.Match.EngineVersion,
 		Port:         port,
 		Game:         g.Match.Game,
 		AutoHostPort: hostPort,
 		Map:          g.Match.Map,
+		StartPosType: startPosType,
+		ModOptions:   g.Match.Script.ModOptions,
+		MapOptions:   g.Match.Script.MapOptions,
+		Restrictions: g.Match.Script.Restrictions,
 
 		AllyTeams: map[int]*scriptAllyTeam{},
 		Teams:     map[int]*scriptTeam{},
@@ -164,12 +218,22 @@ func (g *Game) prepareScript() error {
 	}
 
 	for i, p := range g.Match.Players {
+		// password only used to prevent player spoofing in game for this one match: not used by a human
+		password, err := generatePassword()
+		if err != nil {
+			return nil, err
+		}
+
 		script.Players[i] = &scriptPlayer{
-			Id:   i,
-			Name: p.Name,
-			// password only used to prevent player spoofing in game for this one match: not used by a human
-			Password: generatePassword(),
-			Team:     p.Game.Team,
+			Id:        i,
+			Name:      p.Name,
+			Password:  password,
+			Team:      p.Game.Team,
+			Spectator: p.Game.Spectator,
+		}
+
+		if p.Game.Spectator {
+			continue
 		}
 
 		t, ok := script.Teams[p.Game.Team]
@@ -190,41 +254,38 @@ func (g *Game) prepareScript() error {
 				AllyTeam: p.Game.AllyTeam,
 				// we won't have AIs, so this field being populated by the first player's ID is fine.
 				TeamLeader: i,
+				Side:       p.Game.Side,
+				Color:      p.Game.Color,
+				Handicap:   p.Game.Handicap,
 			}
 		}
 
 		_, ok = script.AllyTeams[p.Game.AllyTeam]
 		if !ok {
-			script.AllyTeams[p.Game.AllyTeam] = &scriptAllyTeam{
+			a := &scriptAllyTeam{
 				Id:        p.Game.AllyTeam,
 				NumAllies: 0,
 			}
-		}
-	}
-
-	prefix := "games"
-	path := fmt.Sprintf("%s/%s/%d", prefix, g.Match.QueueName, time.Now().Unix())
 
-	g.GameDir = path
+			box, ok := g.Match.Script.StartBoxes[p.Game.AllyTeam]
+			if ok {
+				a.HasStartBox = true
+				a.StartLeft = box.Left
+				a.StartTop = box.Top
+				a.StartRight = box.Right
+				a.StartBottom = box.Bottom
+			}
 
-	err = generateStartScript(path, script)
-	if err != nil {
-		return fmt.Errorf("game.PrepareScript: could not create startscript: %v", err)
+			script.AllyTeams[p.Game.AllyTeam] = a
+		}
 	}
 
-	g.Script = script
-
-	return nil
+	return script, nil
 }
 
 func generateStartScript(path string, script *startScript) error {
-	tmpl, err := template.New("startScript").Parse(scriptTmpl)
-	if err != nil {
-		return fmt.Errorf("failed to compile template: %v", err)
-	}
-
 	//TODO: configurable
-	err = os.MkdirAll(path, 0744)
+	err := os.MkdirAll(path, 0744)
 	if err != nil {
 		return fmt.Errorf("could not mkdir %v", err)
 	}
@@ -235,23 +296,41 @@ func generateStartScript(path string, script *startScript) error {
 	}
 	defer f.Close()
 
-	err = tmpl.Execute(f, script)
+	err = script.write(f)
 	if err != nil {
-		return fmt.Errorf("failed to execute template: %v", err)
+		return fmt.Errorf("failed to write startscript: %v", err)
 	}
 	return nil
 }
 
-var alphabet string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!$%^&*()"
+// letters and digits only: safe to put in a startscript value as-is
+var alphabet string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+const passwordLength = 32
+
+// a token so people can't spoof in a game. it has to be unguessable, so it
+// comes from crypto/rand.
+func generatePassword() (string, error) {
+	// bytes at or above this would favour the start of the alphabet
+	limit := 256 - 256%len(alphabet)
+
+	password := make([]byte, 0, passwordLength)
+	buf := make([]byte, passwordLength)
+	for len(password) < passwordLength {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return "", fmt.Errorf("game.generatePassword: could not read random bytes: %v", err)
+		}
 
-// just a token so people can't spoof in a game. non-crypto rand is fine.
-func generatePassword() string {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	password := make([]byte, 50)
-	for i, _ := range password {
-		password[i] = alphabet[r.Intn(len(alphabet))]
+		for _, b := range buf {
+			if int(b) >= limit || len(password) == passwordLength {
+				continue
+			}
+			password = append(password, alphabet[int(b)%len(alphabet)])
+		}
 	}
-	return string(password)
+
+	return string(password), nil
 }
 
 func openPort() (string, error) {
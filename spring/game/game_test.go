@@ -0,0 +1,32 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePasswords(t *testing.T) {
+	seen := map[string]bool{}
+	// a full 8v8 match's worth of passwords, many times over
+	for round := 0; round < 500; round++ {
+		for player := 0; player < 16; player++ {
+			password, err := generatePassword()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(password) != passwordLength {
+				t.Fatalf("password %q is %d long, want %d", password, len(password), passwordLength)
+			}
+			for _, r := range password {
+				if !strings.ContainsRune(alphabet, r) {
+					t.Fatalf("password %q has %q, which isn't in the alphabet", password, r)
+				}
+			}
+			if seen[password] {
+				t.Fatalf("password %q generated twice", password)
+			}
+			seen[password] = true
+		}
+	}
+}
@@ -1,21 +1,42 @@
 package game
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
 type scriptAllyTeam struct {
 	Id        int
 	NumAllies int
+	// start box edges as fractions of the map size; only written if HasStartBox
+	HasStartBox bool
+	StartLeft   float64
+	StartTop    float64
+	StartRight  float64
+	StartBottom float64
 }
 
 type scriptPlayer struct {
-	Id       int
-	Name     string
-	Password string
-	Team     int
+	Id        int
+	Name      string
+	Password  string
+	Team      int
+	Spectator bool
 }
 
 type scriptTeam struct {
 	Id         int
 	AllyTeam   int
 	TeamLeader int
+	Side       string
+	// red, green, blue from 0 to 1; empty lets the game choose
+	Color    []float64
+	Handicap int
 }
 
 type startScript struct {
@@ -25,41 +46,242 @@ type startScript struct {
 	Engine       string
 	AutoHostPort string
 	Map          string
+	StartPosType int
+	ModOptions   map[string]string
+	MapOptions   map[string]string
+	Restrictions map[string]int
 	AllyTeams    map[int]*scriptAllyTeam
 	Players      []*scriptPlayer
 	Teams        map[int]*scriptTeam
 }
 
-var scriptTmpl string = `[game]
-{
-	AutoHostIP=127.0.0.1;
-	AutoHostPort={{.AutoHostPort}};
-	GameType={{.Game}};
-	HostIP=;
-	HostPort={{.Port}};
-	IsHost=1;
-	MapName={{.Map}};
-	OnlyLocal=0;
-	StartPosType=1;
-	{{range .AllyTeams}}
-	[allyteam{{.Id}}]
-	{
-		NumAllies={{.NumAllies}};
-	}
-	{{end}}
-	{{range .Players}}
-	[player{{.Id}}]
-	{
-		name={{.Name}};
-		password={{.Password}};
-		team={{.Team}};
-	}
-	{{end}}
-	{{range .Teams}}
-	[team{{.Id}}]
-	{
-		AllyTeam={{.AllyTeam}};
-		TeamLeader={{.TeamLeader}};
-	}
-	{{end}}
-}`
+// write renders the script in spring's startscript format.
+func (s *startScript) write(out io.Writer) error {
+	w := newScriptWriter(out)
+
+	w.open("game")
+	w.set("AutoHostIP", "127.0.0.1")
+	w.set("AutoHostPort", s.AutoHostPort)
+	w.set("GameType", s.Game)
+	w.set("HostIP", "")
+	w.set("HostPort", s.Port)
+	w.set("IsHost", 1)
+	w.set("MapName", s.Map)
+	w.set("OnlyLocal", 0)
+	w.set("StartPosType", s.StartPosType)
+
+	w.open("modoptions")
+	for _, key := range sortedKeys(s.ModOptions) {
+		w.set(key, s.ModOptions[key])
+	}
+	w.close()
+
+	w.open("mapoptions")
+	for _, key := range sortedKeys(s.MapOptions) {
+		w.set(key, s.MapOptions[key])
+	}
+	w.close()
+
+	units := make([]string, 0, len(s.Restrictions))
+	for unit := range s.Restrictions {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	w.open("restrict")
+	w.set("NumRestrictions", len(units))
+	for i, unit := range units {
+		w.set(fmt.Sprintf("Unit%d", i), unit)
+		w.set(fmt.Sprintf("Limit%d", i), s.Restrictions[unit])
+	}
+	w.close()
+
+	for _, id := range allyTeamIds(s.AllyTeams) {
+		a := s.AllyTeams[id]
+		w.open(fmt.Sprintf("allyteam%d", a.Id))
+		w.set("NumAllies", a.NumAllies)
+		if a.HasStartBox {
+			w.set("StartRectLeft", a.StartLeft)
+			w.set("StartRectTop", a.StartTop)
+			w.set("StartRectRight", a.StartRight)
+			w.set("StartRectBottom", a.StartBottom)
+		}
+		w.close()
+	}
+
+	for _, p := range s.Players {
+		w.open(fmt.Sprintf("player%d", p.Id))
+		w.set("Name", p.Name)
+		w.set("Password", p.Password)
+		if p.Spectator {
+			w.set("Spectator", 1)
+		} else {
+			w.set("Spectator", 0)
+			w.set("Team", p.Team)
+		}
+		w.close()
+	}
+
+	for _, id := range teamIds(s.Teams) {
+		t := s.Teams[id]
+		w.open(fmt.Sprintf("team%d", t.Id))
+		w.set("AllyTeam", t.AllyTeam)
+		w.set("TeamLeader", t.TeamLeader)
+		w.set("Handicap", t.Handicap)
+		if t.Side != "" {
+			w.set("Side", t.Side)
+		}
+		if len(t.Color) == 3 {
+			w.set("RGBColor", fmt.Sprintf("%s %s %s", formatFloat(t.Color[0]), formatFloat(t.Color[1]), formatFloat(t.Color[2])))
+		}
+		w.close()
+	}
+
+	w.close()
+	return w.flush()
+}
+
+// scriptWriter emits the nested [section] { key=value; } format used by
+// spring startscripts. section names and keys must be plain identifiers. the
+// format has no escape sequences, so values which could end their key early,
+// open a section or start a comment are rejected rather than mangled: a
+// "fixed" player or map name would no longer match the real one anyway. the
+// first problem is kept and reported by flush; nothing is written after it.
+type scriptWriter struct {
+	out   *bufio.Writer
+	depth int
+	err   error
+}
+
+func newScriptWriter(out io.Writer) *scriptWriter {
+	return &scriptWriter{out: bufio.NewWriter(out)}
+}
+
+func (w *scriptWriter) open(section string) {
+	w.check(checkIdentifier(section), "section", section)
+	w.line("[" + section + "]")
+	w.line("{")
+	w.depth++
+}
+
+func (w *scriptWriter) close() {
+	w.depth--
+	w.line("}")
+}
+
+func (w *scriptWriter) set(key string, value interface{}) {
+	w.check(checkIdentifier(key), "key", key)
+
+	var formatted string
+	switch v := value.(type) {
+	case string:
+		formatted = escapeValue(v)
+		w.check(checkValue(formatted), "value for", key)
+	case int:
+		formatted = strconv.Itoa(v)
+	case float64:
+		formatted = formatFloat(v)
+	default:
+		w.check(fmt.Errorf("unsupported type %T", value), "value for", key)
+	}
+
+	w.line(key + "=" + formatted + ";")
+}
+
+func (w *scriptWriter) check(err error, what string, name string) {
+	if err != nil && w.err == nil {
+		w.err = fmt.Errorf("%s %q: %v", what, name, err)
+	}
+}
+
+func (w *scriptWriter) line(text string) {
+	if w.err != nil {
+		return
+	}
+
+	w.out.WriteString(strings.Repeat("\t", w.depth))
+	w.out.WriteString(text)
+	w.out.WriteString("\n")
+}
+
+func (w *scriptWriter) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.out.Flush()
+}
+
+func checkIdentifier(name string) error {
+	if name == "" {
+		return fmt.Errorf("is empty")
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("contains %q: only letters, digits and '_' are allowed", r)
+		}
+	}
+	return nil
+}
+
+// characters and sequences which would change the structure of the script
+// if they appeared in a value
+var unsafeInValue = []string{";", "{", "}", "//", "/*"}
+
+func checkValue(value string) error {
+	// spring trims values, so " bob" would reach the game as "bob"
+	if strings.TrimSpace(value) != value {
+		return fmt.Errorf("has leading or trailing whitespace")
+	}
+
+	for _, unsafe := range unsafeInValue {
+		if strings.Contains(value, unsafe) {
+			return fmt.Errorf("contains %q", unsafe)
+		}
+	}
+
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("contains control character %q", r)
+		}
+	}
+	return nil
+}
+
+// tabs are the one harmless control character players manage to type into
+// names and descriptions; they become spaces. everything else goes through
+// checkValue untouched.
+func escapeValue(value string) string {
+	return strings.Replace(value, "\t", " ", -1)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func allyTeamIds(m map[int]*scriptAllyTeam) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func teamIds(m map[int]*scriptTeam) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,211 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// hostile strings: each either has to be refused, or come back out of the
+// rendered script unchanged without adding sections or keys
+var hostile = []struct {
+	name  string
+	value string
+	safe  bool
+}{
+	{"plain", "bob", true},
+	{"semicolon", "bob;Password=x", false},
+	{"open brace", "bob{", false},
+	{"close brace", "bob}", false},
+	{"section", "[TAG]bob", true},
+	{"section mid value", "bob[game]", true},
+	{"close bracket", "bob]", true},
+	{"equals", "bob=alice", true},
+	{"newline", "bob\n[player9]", false},
+	{"carriage return", "bob\r", false},
+	{"nul", "bob\x00", false},
+	{"leading space", " bob", false},
+	{"trailing space", "bob ", false},
+	{"inner space", "bob the builder", true},
+	// tabs are turned into spaces rather than refused
+	{"tab", "bob\tbuilder", true},
+	{"line comment", "bob//x", false},
+	{"block comment", "bob/*x", false},
+	{"empty", "", true},
+}
+
+func testGame(name string, modOptions map[string]string) *Game {
+	match := &queue.Match{
+		Id:            1,
+		QueueName:     "test",
+		Game:          "Balanced Annihilation V9.46",
+		Map:           "DeltaSiegeDry",
+		EngineVersion: "103.0",
+		Script:        queue.ScriptOptions{ModOptions: modOptions},
+	}
+
+	for i, n := range []string{name, "alice"} {
+		p := queue.NewPlayer(n)
+		p.Game = &queue.Seat{Team: i, AllyTeam: i}
+		match.Players = append(match.Players, p)
+	}
+
+	return New(match, nil, nil)
+}
+
+func render(t *testing.T, g *Game) (map[string]string, error) {
+	var out bytes.Buffer
+	err := g.RenderScript(&out)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := parseScript(out.String())
+	if err != nil {
+		t.Fatalf("rendered script doesn't parse: %v\n%s", err, out.String())
+	}
+	return keys, nil
+}
+
+func TestRenderScriptPlayerNames(t *testing.T) {
+	baseline, err := render(t, testGame("bob", nil))
+	if err != nil {
+		t.Fatalf("baseline: %v", err)
+	}
+
+	for _, c := range hostile {
+		want := strings.Replace(c.value, "\t", " ", -1)
+
+		keys, err := render(t, testGame(c.value, nil))
+		if !c.safe {
+			if err == nil {
+				t.Errorf("%s: %q was accepted as a player name", c.name, c.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %q was rejected: %v", c.name, c.value, err)
+			continue
+		}
+
+		if got := keys["game/player0/Name"]; got != want {
+			t.Errorf("%s: name came back as %q, want %q", c.name, got, want)
+		}
+		if !reflect.DeepEqual(paths(keys), paths(baseline)) {
+			t.Errorf("%s: script structure changed:\n got %v\nwant %v", c.name, paths(keys), paths(baseline))
+		}
+	}
+}
+
+func TestRenderScriptOptionValues(t *testing.T) {
+	for _, c := range hostile {
+		want := strings.Replace(c.value, "\t", " ", -1)
+
+		keys, err := render(t, testGame("bob", map[string]string{"option": c.value}))
+		if !c.safe {
+			if err == nil {
+				t.Errorf("%s: %q was accepted as a mod option value", c.name, c.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %q was rejected: %v", c.name, c.value, err)
+			continue
+		}
+
+		if got := keys["game/modoptions/option"]; got != want {
+			t.Errorf("%s: option came back as %q, want %q", c.name, got, want)
+		}
+	}
+}
+
+func TestRenderScriptOptionKeys(t *testing.T) {
+	for _, c := range hostile {
+		_, err := render(t, testGame("bob", map[string]string{c.value: "1"}))
+		// keys are identifiers: only the plain name gets through
+		if c.value == "bob" {
+			if err != nil {
+				t.Errorf("%s: key %q was rejected: %v", c.name, c.value, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: %q was accepted as a mod option key", c.name, c.value)
+		}
+	}
+}
+
+func paths(keys map[string]string) []string {
+	all := make([]string, 0, len(keys))
+	for k := range keys {
+		all = append(all, k)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// parseScript reads a startscript the way spring does: [section] { ... }
+// blocks, key=value; pairs with whitespace trimmed, and // and /* */
+// comments. it returns the values keyed by section path and key.
+func parseScript(script string) (map[string]string, error) {
+	keys := map[string]string{}
+	var sections []string
+
+	s := script
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		switch {
+		case s == "":
+			if len(sections) != 0 {
+				return nil, fmt.Errorf("unclosed section %v", sections)
+			}
+			return keys, nil
+		case strings.HasPrefix(s, "//"):
+			end := strings.Index(s, "\n")
+			if end < 0 {
+				end = len(s)
+			}
+			s = s[end:]
+		case strings.HasPrefix(s, "/*"):
+			end := strings.Index(s, "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			s = s[end+2:]
+		case s[0] == '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated section name")
+			}
+			sections = append(sections, s[1:end])
+			s = strings.TrimLeft(s[end+1:], " \t\r\n")
+			if !strings.HasPrefix(s, "{") {
+				return nil, fmt.Errorf("section %q has no body", sections[len(sections)-1])
+			}
+			s = s[1:]
+		case s[0] == '}':
+			if len(sections) == 0 {
+				return nil, fmt.Errorf("unbalanced '}'")
+			}
+			sections = sections[:len(sections)-1]
+			s = s[1:]
+		default:
+			eq := strings.Index(s, "=")
+			end := strings.Index(s, ";")
+			if eq < 0 || end < 0 || end < eq {
+				return nil, fmt.Errorf("malformed key near %q", s)
+			}
+			key := strings.TrimSpace(s[:eq])
+			path := strings.Join(append(sections, key), "/")
+			if _, dup := keys[path]; dup {
+				return nil, fmt.Errorf("duplicate key %q", path)
+			}
+			keys[path] = strings.TrimSpace(s[eq+1 : end])
+			s = s[end+1:]
+		}
+	}
+}
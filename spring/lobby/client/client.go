@@ -2,9 +2,12 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/kanatohodets/go-match/spring/lobby/protocol"
 	"net"
@@ -12,16 +15,52 @@ import (
 	"time"
 )
 
+// ErrNotConnected is returned when sending without a live connection.
+var ErrNotConnected = errors.New("client: not connected to the lobby server")
+
+type outgoing struct {
+	raw    []byte
+	result chan error
+}
+
 type Client struct {
-	conn   net.Conn
-	Events chan *protocol.Message
-	exit   chan struct{}
-	active bool
-	mut    sync.RWMutex
+	conn     net.Conn
+	outbound chan *outgoing
+	Events   chan *protocol.Message
+	exit     chan struct{}
+	active   bool
+	mut      sync.RWMutex
+
+	config Config
+	// protected by mut
+	stats      EventStats
+	waiters    map[*waiter]struct{}
+	loginState LoginState
+	// everyone online in the lobby, by name
+	users map[string]*protocol.User
+	// keeps users up to date; see users.go
+	tracking *protocol.Registry
 }
 
-func New() *Client {
-	return &Client{}
+func New(config Config) *Client {
+	if config.EventBuffer <= 0 {
+		config.EventBuffer = DefaultEventBuffer
+	}
+
+	if config.OutboundBuffer <= 0 {
+		config.OutboundBuffer = DefaultOutboundBuffer
+	}
+
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+
+	c := &Client{
+		config:  config,
+		waiters: make(map[*waiter]struct{}),
+	}
+	c.tracking = c.userCommands()
+	return c
 }
 
 func (c *Client) Active() bool {
@@ -31,7 +70,7 @@ func (c *Client) Active() bool {
 }
 
 func (c *Client) Connect(lobbyServer string) error {
-	conn, err := net.Dial("tcp", lobbyServer)
+	conn, err := c.dial(lobbyServer)
 	if err != nil {
 		return err
 	}
@@ -39,27 +78,53 @@ func (c *Client) Connect(lobbyServer string) error {
 	c.mut.Lock()
 	c.exit = make(chan struct{})
 	c.conn = conn
-	c.Events = make(chan *protocol.Message)
+	c.outbound = make(chan *outgoing, c.config.OutboundBuffer)
+	c.Events = make(chan *protocol.Message, c.config.EventBuffer)
+	c.stats = EventStats{Capacity: c.config.EventBuffer}
+	c.loginState = LoggedOut
+	c.users = make(map[string]*protocol.User)
 	c.active = true
 	c.mut.Unlock()
 
-	// c.exit is closed by 'read' when the socket closes
+	// c.exit is closed by 'read' when the socket closes; 'write' exits with it
 	go c.read()
+	go c.write(c.conn, c.outbound, c.exit)
 
 	return nil
 }
 
-func (c *Client) Disconnect() {
-	c.send("EXIT", []string{})
+// Disconnect says goodbye to the server. anything already queued to send,
+// like CLOSEQUEUE during shutdown, is written before the EXIT.
+func (c *Client) Disconnect() error {
+	err := c.send("EXIT", []string{})
+
+	c.mut.RLock()
+	conn := c.conn
+	c.mut.RUnlock()
+
 	// this closes the scanner in 'read', which closes c.exit
-	c.conn.Close()
+	if conn != nil {
+		conn.Close()
+	}
+	return err
 }
 
 func (c *Client) Done() {
 	<-c.exit
 }
 
-func (c *Client) Login(user string, pass string) {
+// Stats reports how far behind the consumer of Events is.
+func (c *Client) Stats() EventStats {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	stats := c.stats
+	stats.Pending = len(c.Events)
+	return stats
+}
+
+// Login logs in and waits for the server's verdict. a refusal is returned as
+// a *LoginError.
+func (c *Client) Login(ctx context.Context, user string, pass string) error {
 	hash := md5.Sum([]byte(pass))
 
 	params := []string{
@@ -72,53 +137,147 @@ func (c *Client) Login(user string, pass string) {
 		"sp cl p",
 	}
 
-	// this exits when 'Active' is no longer true.
-	go c.keepAlive()
+	c.mut.RLock()
+	exit := c.exit
+	c.mut.RUnlock()
+
+	// this exits along with the connection it was started for
+	go c.keepAlive(exit)
 
-	c.send("LOGIN", params)
+	c.setLoginState(LoggingIn)
+	reply, err := c.request(ctx, "LOGIN", params, func(msg *protocol.Message) bool {
+		switch msg.Command {
+		case "ACCEPTED", "DENIED", "AGREEMENT":
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		c.setLoginState(LoggedOut)
+		return err
+	}
+
+	switch reply.Command {
+	case "ACCEPTED":
+		c.setLoginState(LoggedIn)
+		return nil
+
+	case "AGREEMENT":
+		c.setLoginState(AgreementRequired)
+		return &LoginError{
+			State:  AgreementRequired,
+			Reason: "the server's terms must be accepted: log in once with a regular lobby client",
+		}
+
+	default:
+		reason := string(reply.Data)
+		state := classifyDenied(reason)
+		c.setLoginState(state)
+		return &LoginError{
+			State:  state,
+			Reason: reason,
+		}
+	}
 }
 
-func (c *Client) OpenQueue(queueDef *protocol.QueueDefinition) {
-	c.sendJSON("OPENQUEUE", queueDef)
+// OpenQueue asks the server to host a queue, and waits until it has.
+func (c *Client) OpenQueue(ctx context.Context, queueDef *protocol.QueueDefinition) error {
+	reply, err := c.requestJSON(ctx, "OPENQUEUE", queueDef, func(msg *protocol.Message) bool {
+		if failedFor("OPENQUEUE", msg) {
+			return true
+		}
+
+		if msg.Command != "QUEUEOPENED" {
+			return false
+		}
+
+		var opened protocol.QueueDefinition
+		err := json.Unmarshal(msg.Data, &opened)
+		return err == nil && opened.Name == queueDef.Name
+	})
+	if err != nil {
+		return err
+	}
+
+	if reply.Command == "FAILED" {
+		return fmt.Errorf("client.OpenQueue: server refused %v: %v", queueDef.Name, protocol.ParseFailed(reply.Data).Message)
+	}
+	return nil
 }
 
-func (c *Client) CloseQueue(queue string) {
-	c.sendJSON("CLOSEQUEUE", &protocol.CloseQueue{Name: queue})
+// UpdateQueue replaces the definition of a queue we host, and waits for the
+// server to confirm it.
+func (c *Client) UpdateQueue(ctx context.Context, queueDef *protocol.QueueDefinition) error {
+	reply, err := c.requestJSON(ctx, "UPDATEQUEUE", queueDef, func(msg *protocol.Message) bool {
+		if failedFor("UPDATEQUEUE", msg) {
+			return true
+		}
+
+		if msg.Command != "QUEUEUPDATED" {
+			return false
+		}
+
+		var updated protocol.QueueDefinition
+		err := json.Unmarshal(msg.Data, &updated)
+		return err == nil && updated.Name == queueDef.Name
+	})
+	if err != nil {
+		return err
+	}
+
+	if reply.Command == "FAILED" {
+		return fmt.Errorf("client.UpdateQueue: server refused %v: %v", queueDef.Name, protocol.ParseFailed(reply.Data).Message)
+	}
+	return nil
 }
 
-func (c *Client) JoinQueueAccept(queue string, users []string) {
-	c.sendJSON("JOINQUEUEACCEPT", &protocol.JoinQueueAccept{
+// CloseQueue stops hosting a queue. the reason is passed on to the players
+// still waiting in it.
+func (c *Client) CloseQueue(queue string, reason string) error {
+	return c.sendJSON("CLOSEQUEUE", &protocol.CloseQueue{
+		Name:   queue,
+		Reason: reason,
+	})
+}
+
+// SayPrivate sends a chat message to one user.
+func (c *Client) SayPrivate(user string, message string) error {
+	return c.send("SAYPRIVATE", []string{user, message})
+}
+
+func (c *Client) JoinQueueAccept(queue string, users []string) error {
+	return c.sendJSON("JOINQUEUEACCEPT", &protocol.JoinQueueAccept{
 		Name:      queue,
 		UserNames: users,
 	})
 }
 
-func (c *Client) JoinQueueDeny(queue string, users []string, reason string) {
-	c.sendJSON("JOINQUEUEDENY", &protocol.JoinQueueDeny{
+func (c *Client) JoinQueueDeny(queue string, users []string, reason string) error {
+	return c.sendJSON("JOINQUEUEDENY", &protocol.JoinQueueDeny{
 		Name:      queue,
 		UserNames: users,
 		Reason:    reason,
 	})
 }
 
-func (c *Client) ReadyCheck(queue string, users []string, responseTime int) {
-	c.sendJSON("READYCHECK", &protocol.ReadyCheck{
+func (c *Client) ReadyCheck(queue string, users []string, responseTime int) error {
+	return c.sendJSON("READYCHECK", &protocol.ReadyCheck{
 		Name:         queue,
 		UserNames:    users,
 		ResponseTime: responseTime,
 	})
 }
 
-func (c *Client) ReadyCheckResult(queue string, users []string, status string) {
-	c.sendJSON("READYCHECKRESULT", &protocol.ReadyCheckResult{
+func (c *Client) ReadyCheckResult(queue string, users []string, status string) error {
+	return c.sendJSON("READYCHECKRESULT", &protocol.ReadyCheckResult{
 		Name:      queue,
 		UserNames: users,
 		Result:    status,
 	})
 }
 
-func (c *Client) ConnectUser(user string, ip string, port string, password string, engine string) {
-	c.sendJSON("CONNECTUSER", &protocol.ConnectUser{
+func (c *Client) ConnectUser(user string, ip string, port string, password string, engine string) error {
+	return c.sendJSON("CONNECTUSER", &protocol.ConnectUser{
 		UserName: user,
 		IP:       ip,
 		Port:     port,
@@ -127,7 +286,9 @@ func (c *Client) ConnectUser(user string, ip string, port string, password strin
 	})
 }
 
-func (c *Client) send(command string, params []string) {
+// send queues a command for the writer goroutine and waits until it has been
+// written, or has failed to be.
+func (c *Client) send(command string, params []string) error {
 	msg := protocol.Prepare(command, params)
 
 	raw := msg.Bytes()
@@ -136,19 +297,34 @@ func (c *Client) send(command string, params []string) {
 		"output": string(raw),
 	}).Debug("OUT")
 
-	_, err := c.conn.Write(raw)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"event":   "send",
-			"command": command,
-			"data":    params,
-			"error":   err,
-		}).Warn("error sending to spring server")
-		c.conn.Close()
+	c.mut.RLock()
+	outbound, exit, active := c.outbound, c.exit, c.active
+	c.mut.RUnlock()
+
+	if !active {
+		return ErrNotConnected
+	}
+
+	out := &outgoing{
+		raw:    raw,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case outbound <- out:
+	case <-exit:
+		return ErrNotConnected
+	}
+
+	select {
+	case err := <-out.result:
+		return err
+	case <-exit:
+		return ErrNotConnected
 	}
 }
 
-func (c *Client) sendJSON(command string, payload interface{}) {
+func (c *Client) sendJSON(command string, payload interface{}) error {
 	b, err := json.Marshal(payload)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -157,10 +333,34 @@ func (c *Client) sendJSON(command string, payload interface{}) {
 			"payload": payload,
 			"error":   err,
 		}).Error("could not encode sendJSON payload")
-		return
+		return fmt.Errorf("client.sendJSON: could not encode %v payload: %v", command, err)
 	}
 
-	c.send(command, []string{string(b)})
+	return c.send(command, []string{string(b)})
+}
+
+// write is the only goroutine which writes to conn, so lines from concurrent
+// senders can't interleave. it exits along with the connection.
+func (c *Client) write(conn net.Conn, outbound chan *outgoing, exit chan struct{}) {
+	for {
+		select {
+		case out := <-outbound:
+			conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+			_, err := conn.Write(out.raw)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event": "write",
+					"error": err,
+				}).Warn("error sending to spring server, closing connection")
+				conn.Close()
+				err = fmt.Errorf("client.send: %v", err)
+			}
+			out.result <- err
+
+		case <-exit:
+			return
+		}
+	}
 }
 
 func (c *Client) read() {
@@ -171,7 +371,14 @@ func (c *Client) read() {
 			break
 		}
 
-		msg := protocol.Parse(scanner.Text())
+		msg, err := protocol.Parse(scanner.Text())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "read",
+				"error": err,
+			}).Warn("skipping malformed line from server")
+			continue
+		}
 
 		log.WithFields(log.Fields{
 			"event":   "read",
@@ -179,23 +386,63 @@ func (c *Client) read() {
 			"data":    string(msg.Data),
 		}).Debug("IN")
 
-		c.Events <- msg
+		c.tracking.Dispatch(msg)
+		c.resolve(msg)
+		if !c.deliver(msg) {
+			break
+		}
 	}
 
 	c.mut.Lock()
 	c.active = false
+	c.loginState = LoggedOut
 	close(c.Events)
 	close(c.exit)
 	c.mut.Unlock()
 }
 
-func (c *Client) keepAlive() {
+// deliver hands a message to the consumer of Events, applying the overflow
+// policy if it has fallen too far behind. returns false if the connection is
+// being dropped.
+func (c *Client) deliver(msg *protocol.Message) bool {
+	select {
+	case c.Events <- msg:
+	default:
+		if c.config.Overflow != OverflowBlock {
+			log.WithFields(log.Fields{
+				"event":    "read",
+				"command":  msg.Command,
+				"capacity": c.config.EventBuffer,
+			}).Error("event buffer full: the matchbot is not keeping up with the server. disconnecting")
+			c.conn.Close()
+			return false
+		}
+
+		log.WithFields(log.Fields{
+			"event":    "read",
+			"capacity": c.config.EventBuffer,
+		}).Warn("event buffer full, waiting for room before reading further")
+		c.Events <- msg
+	}
+
+	pending := len(c.Events)
+	c.mut.Lock()
+	c.stats.Delivered++
+	if pending > c.stats.HighWater {
+		c.stats.HighWater = pending
+	}
+	c.mut.Unlock()
+
+	return true
+}
+
+func (c *Client) keepAlive(exit chan struct{}) {
 	for {
-		if c.Active() {
-			time.Sleep(20 * time.Second)
+		select {
+		case <-time.After(20 * time.Second):
 			c.send("PING", nil)
-		} else {
-			break
+		case <-exit:
+			return
 		}
 	}
 }
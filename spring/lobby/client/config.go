@@ -0,0 +1,48 @@
+package client
+
+import "time"
+
+// DefaultEventBuffer is the number of incoming messages which may wait on the
+// consumer of Client.Events before the overflow policy kicks in.
+const DefaultEventBuffer = 1024
+
+// DefaultOutboundBuffer is the number of outgoing messages which may queue
+// up for the writer before senders block.
+const DefaultOutboundBuffer = 256
+
+// DefaultWriteTimeout bounds how long a single message may take to write.
+const DefaultWriteTimeout = 10 * time.Second
+
+// OverflowPolicy says what read does when Events is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect drops the connection, logging why. the reconnect
+	// starts from a clean slate instead of falling ever further behind.
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowBlock stops reading from the socket until there is room.
+	OverflowBlock
+)
+
+// Config tunes a Client. the zero value gets the defaults.
+type Config struct {
+	EventBuffer int
+	Overflow    OverflowPolicy
+
+	OutboundBuffer int
+	WriteTimeout   time.Duration
+
+	TLS TLSConfig
+}
+
+// EventStats reports on the incoming event pipeline.
+type EventStats struct {
+	// messages waiting to be consumed from Events
+	Pending int
+	// size of the Events buffer
+	Capacity int
+	// the most messages ever waiting at once, this connection
+	HighWater int
+	// messages delivered to Events, this connection
+	Delivered uint64
+}
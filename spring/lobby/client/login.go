@@ -0,0 +1,121 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoginState tracks where the client is in the login flow.
+type LoginState int
+
+const (
+	LoggedOut LoginState = iota
+	LoggingIn
+	LoggedIn
+	// the server refused the credentials: bad password or no such account
+	LoginDenied
+	// the account has to accept the server's terms before it can log in
+	AgreementRequired
+	// banned with no end date
+	LoginBanned
+	// too many attempts in too short a time
+	LoginFlooded
+	// banned for a while
+	LoginTempBanned
+	// turned down for some other reason, like the account already being
+	// logged in elsewhere
+	LoginRefused
+)
+
+func (s LoginState) String() string {
+	switch s {
+	case LoggedOut:
+		return "logged out"
+	case LoggingIn:
+		return "logging in"
+	case LoggedIn:
+		return "logged in"
+	case LoginDenied:
+		return "denied"
+	case AgreementRequired:
+		return "agreement required"
+	case LoginBanned:
+		return "banned"
+	case LoginFlooded:
+		return "flood protection"
+	case LoginTempBanned:
+		return "temporarily banned"
+	case LoginRefused:
+		return "refused"
+	}
+	return fmt.Sprintf("LoginState(%d)", int(s))
+}
+
+// LoginError is the server turning a login down.
+type LoginError struct {
+	State  LoginState
+	Reason string
+}
+
+func (e *LoginError) Error() string {
+	return fmt.Sprintf("client.Login: %v: %v", e.State, e.Reason)
+}
+
+// Permanent is true if logging in again will fail the same way until someone
+// changes the bot's configuration or account. only reasons we recognise count:
+// anything else might wear off, so it's worth retrying.
+func (e *LoginError) Permanent() bool {
+	switch e.State {
+	case LoginDenied, LoginBanned, AgreementRequired:
+		return true
+	}
+	return false
+}
+
+// wording in DENIED reasons, lowercased
+var (
+	floodWords      = []string{"flood", "too many"}
+	banWords        = []string{"banned"}
+	tempBanWords    = []string{"until", "expires", "temporar", "remaining"}
+	badAccountWords = []string{"password", "no such user", "no user named", "not found", "unknown user", "invalid username", "bad username", "does not exist"}
+)
+
+// classifyDenied sorts a DENIED reason into a state. the server only gives a
+// free-text reason, so this goes by its wording; whatever we don't recognise
+// is LoginRefused.
+func classifyDenied(reason string) LoginState {
+	lower := strings.ToLower(reason)
+	switch {
+	case containsAny(lower, floodWords):
+		return LoginFlooded
+	case containsAny(lower, banWords) && containsAny(lower, tempBanWords):
+		return LoginTempBanned
+	case containsAny(lower, banWords):
+		return LoginBanned
+	case containsAny(lower, badAccountWords):
+		return LoginDenied
+	}
+	return LoginRefused
+}
+
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginState is where the current connection is in the login flow.
+func (c *Client) LoginState() LoginState {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.loginState
+}
+
+func (c *Client) setLoginState(state LoginState) {
+	c.mut.Lock()
+	c.loginState = state
+	c.mut.Unlock()
+}
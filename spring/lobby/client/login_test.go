@@ -0,0 +1,36 @@
+package client
+
+import "testing"
+
+func TestClassifyDenied(t *testing.T) {
+	cases := []struct {
+		reason    string
+		state     LoginState
+		permanent bool
+	}{
+		{"Invalid password", LoginDenied, true},
+		{"Bad username/password", LoginDenied, true},
+		{"No user named FooUser", LoginDenied, true},
+		{"No such user.", LoginDenied, true},
+		{"User not found", LoginDenied, true},
+		{"You are banned from this server! (Reason: cheating)", LoginBanned, true},
+		{"You are banned until 2026-12-01 (Reason: spam)", LoginTempBanned, false},
+		{"Banned, expires in 3 days", LoginTempBanned, false},
+		{"Already logged in", LoginRefused, false},
+		{"Flood protection: too many login attempts", LoginFlooded, false},
+		{"Server is full, try later", LoginRefused, false},
+		{"", LoginRefused, false},
+	}
+
+	for _, c := range cases {
+		state := classifyDenied(c.reason)
+		if state != c.state {
+			t.Errorf("%q classified as %v, want %v", c.reason, state, c.state)
+		}
+
+		err := &LoginError{State: state, Reason: c.reason}
+		if err.Permanent() != c.permanent {
+			t.Errorf("%q: Permanent() is %v, want %v", c.reason, err.Permanent(), c.permanent)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+)
+
+// a waiter is a request looking for its reply among incoming messages
+type waiter struct {
+	match func(msg *protocol.Message) bool
+	reply chan *protocol.Message
+}
+
+// request sends a command, then waits for the first incoming message that
+// match accepts. replies still go out on Events as usual.
+func (c *Client) request(ctx context.Context, command string, params []string, match func(msg *protocol.Message) bool) (*protocol.Message, error) {
+	w := &waiter{
+		match: match,
+		reply: make(chan *protocol.Message, 1),
+	}
+
+	c.mut.Lock()
+	exit := c.exit
+	c.waiters[w] = struct{}{}
+	c.mut.Unlock()
+
+	defer func() {
+		c.mut.Lock()
+		delete(c.waiters, w)
+		c.mut.Unlock()
+	}()
+
+	err := c.send(command, params)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-w.reply:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("client: no reply to %v: %v", command, ctx.Err())
+	case <-exit:
+		return nil, ErrNotConnected
+	}
+}
+
+func (c *Client) requestJSON(ctx context.Context, command string, payload interface{}, match func(msg *protocol.Message) bool) (*protocol.Message, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("client.requestJSON: could not encode %v payload: %v", command, err)
+	}
+
+	return c.request(ctx, command, []string{string(b)}, match)
+}
+
+// resolve hands msg to every waiter looking for it.
+func (c *Client) resolve(msg *protocol.Message) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for w := range c.waiters {
+		if w.match(msg) {
+			w.reply <- msg
+			delete(c.waiters, w)
+		}
+	}
+}
+
+// failedFor matches the server's FAILED reply to a particular command.
+func failedFor(command string, msg *protocol.Message) bool {
+	return msg.Command == "FAILED" && protocol.ParseFailed(msg.Data).Command == command
+}
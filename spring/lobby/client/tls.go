@@ -0,0 +1,204 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// TLSMode picks how (and whether) the lobby connection is encrypted.
+type TLSMode int
+
+const (
+	// TLSOff is a plain TCP connection
+	TLSOff TLSMode = iota
+	// TLSStartTLS connects in plain TCP and upgrades with the STLS command
+	TLSStartTLS
+	// TLSDirect speaks TLS from the first byte
+	TLSDirect
+	// TLSAuto tries STLS, and falls back to direct TLS if the server refuses
+	// it. it never falls back to plain TCP.
+	TLSAuto
+)
+
+var tlsModeNames = map[TLSMode]string{
+	TLSOff:      "off",
+	TLSStartTLS: "starttls",
+	TLSDirect:   "direct",
+	TLSAuto:     "auto",
+}
+
+func (m TLSMode) String() string {
+	name, ok := tlsModeNames[m]
+	if !ok {
+		return fmt.Sprintf("TLSMode(%d)", int(m))
+	}
+	return name
+}
+
+// ParseTLSMode reads a mode by its String name, e.g. "starttls".
+func ParseTLSMode(name string) (TLSMode, error) {
+	for mode, modeName := range tlsModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return TLSOff, fmt.Errorf("client.ParseTLSMode: unknown mode %q: want off, starttls, direct or auto", name)
+}
+
+// TLSConfig sets up encryption for the lobby connection.
+type TLSConfig struct {
+	Mode TLSMode
+	// PEM bundle of CAs to trust instead of the system roots
+	CAFile string
+	// name to verify the server's certificate against; defaults to the host
+	// being dialed
+	ServerName string
+	// optional client certificate and key, both PEM
+	CertFile string
+	KeyFile  string
+}
+
+// how long the server gets to answer each step of the STLS exchange
+const startTLSTimeout = 10 * time.Second
+
+// longest greeting or STLS reply we'll wait for
+const maxHandshakeLine = 1024
+
+var errStartTLSRefused = errors.New("server refused STLS")
+
+func (c *Client) dial(server string) (net.Conn, error) {
+	mode := c.config.TLS.Mode
+	if mode == TLSOff {
+		return net.Dial("tcp", server)
+	}
+
+	config, err := c.config.TLS.build(server)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == TLSDirect {
+		return tls.Dial("tcp", server, config)
+	}
+
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+
+	secure, err := startTLS(conn, config)
+	if err == errStartTLSRefused && mode == TLSAuto {
+		return tls.Dial("tcp", server, config)
+	}
+
+	return secure, err
+}
+
+func (t TLSConfig) build(server string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: t.ServerName,
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("client.TLSConfig: could not find a host name in %v: %v", server, err)
+		}
+		config.ServerName = host
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("client.TLSConfig: could not read CA bundle: %v", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client.TLSConfig: no certificates found in %v", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client.TLSConfig: could not load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// startTLS waits for the server's greeting, asks for STLS, and hands the
+// connection over to TLS once the server agrees. the server greets again
+// after the handshake, so the plain-text greeting is simply dropped.
+func startTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(startTLSTimeout))
+
+	greeting, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client.startTLS: no greeting from server: %v", err)
+	}
+
+	if !strings.HasPrefix(greeting, "TASServer") {
+		conn.Close()
+		return nil, fmt.Errorf("client.startTLS: unexpected greeting %q", greeting)
+	}
+
+	_, err = conn.Write([]byte("STLS\n"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client.startTLS: could not send STLS: %v", err)
+	}
+
+	reply, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client.startTLS: no reply to STLS: %v", err)
+	}
+
+	if !strings.HasPrefix(reply, "OK") {
+		conn.Close()
+		return nil, errStartTLSRefused
+	}
+
+	secure := tls.Client(conn, config)
+	err = secure.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("client.startTLS: handshake failed: %v", err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return secure, nil
+}
+
+// readLine reads a byte at a time: a buffered reader could swallow the start
+// of the TLS handshake.
+func readLine(conn net.Conn) (string, error) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for {
+		if len(line) > maxHandshakeLine {
+			return "", fmt.Errorf("line longer than %d bytes", maxHandshakeLine)
+		}
+
+		_, err := conn.Read(b)
+		if err != nil {
+			return "", err
+		}
+
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, b[0])
+	}
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// the CA certificate as a PEM file
+	file string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), name+".pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue signs a certificate for 127.0.0.1, and returns it loaded along with
+// the paths of its cert and key files.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "lobby"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"lobby.test"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, file string, kind string, der []byte) {
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeLobby accepts one connection and greets it over TLS, either from the
+// first byte or after an STLS exchange. the greeting only goes out once the
+// handshake has succeeded on the server's side too.
+func fakeLobby(t *testing.T, config *tls.Config, startTLS bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	if !startTLS {
+		listener = tls.NewListener(listener, config)
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		if startTLS {
+			conn.Write([]byte("TASServer 0.38-33-ga5f3b28 * 8201 0\n"))
+			request, err := readLine(conn)
+			if err != nil || request != "STLS" {
+				return
+			}
+			conn.Write([]byte("OK cmd=STLS\n"))
+			conn = tls.Server(conn, config)
+		}
+
+		err = conn.(*tls.Conn).Handshake()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("TASServer 0.38-33-ga5f3b28 * 8201 0\n"))
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestDialTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	serverCert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	_, clientCertFile, clientKeyFile := ca.issue(t, x509.ExtKeyUsageClientAuth)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	plain := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	mutual := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+
+	cases := []struct {
+		name     string
+		server   *tls.Config
+		startTLS bool
+		client   TLSConfig
+		ok       bool
+	}{
+		{"direct", plain, false, TLSConfig{Mode: TLSDirect, CAFile: ca.file}, true},
+		{"starttls", plain, true, TLSConfig{Mode: TLSStartTLS, CAFile: ca.file}, true},
+		{"auto", plain, true, TLSConfig{Mode: TLSAuto, CAFile: ca.file}, true},
+		{"server name", plain, false, TLSConfig{Mode: TLSDirect, CAFile: ca.file, ServerName: "lobby.test"}, true},
+		{"wrong server name", plain, false, TLSConfig{Mode: TLSDirect, CAFile: ca.file, ServerName: "elsewhere.test"}, false},
+		{"ca mismatch direct", plain, false, TLSConfig{Mode: TLSDirect, CAFile: other.file}, false},
+		{"ca mismatch starttls", plain, true, TLSConfig{Mode: TLSStartTLS, CAFile: other.file}, false},
+		{"client cert", mutual, false, TLSConfig{Mode: TLSDirect, CAFile: ca.file, CertFile: clientCertFile, KeyFile: clientKeyFile}, true},
+		{"client cert starttls", mutual, true, TLSConfig{Mode: TLSStartTLS, CAFile: ca.file, CertFile: clientCertFile, KeyFile: clientKeyFile}, true},
+		{"missing client cert", mutual, false, TLSConfig{Mode: TLSDirect, CAFile: ca.file}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr := fakeLobby(t, c.server, c.startTLS)
+			client := New(Config{TLS: c.client})
+
+			conn, err := client.dial(addr)
+			if err == nil {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+				// with TLS 1.3, the server only turns down a client
+				// certificate after the client thinks the handshake is done
+				var greeting string
+				greeting, err = readLine(conn)
+				if err == nil && !strings.HasPrefix(greeting, "TASServer") {
+					t.Fatalf("unexpected greeting %q", greeting)
+				}
+			}
+
+			if c.ok && err != nil {
+				t.Fatalf("could not talk to the server: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Fatalf("talked to a server it shouldn't trust, or which shouldn't trust it")
+			}
+		})
+	}
+}
+
+func TestParseTLSMode(t *testing.T) {
+	for _, mode := range []TLSMode{TLSOff, TLSStartTLS, TLSDirect, TLSAuto} {
+		parsed, err := ParseTLSMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("%v parsed as %v, %v", mode, parsed, err)
+		}
+	}
+
+	_, err := ParseTLSMode("sometimes")
+	if err == nil {
+		t.Errorf("parsed a mode that doesn't exist")
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"strings"
+)
+
+// User looks up someone who is online in the lobby.
+func (c *Client) User(name string) (protocol.User, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	user, ok := c.users[name]
+	if !ok {
+		return protocol.User{}, false
+	}
+	return *user, true
+}
+
+// Users lists everyone online in the lobby.
+func (c *Client) Users() []protocol.User {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	users := make([]protocol.User, 0, len(c.users))
+	for _, user := range c.users {
+		users = append(users, *user)
+	}
+	return users
+}
+
+// userCommands keeps the user registry up to date from the server's
+// messages. everything else is left to whoever reads Events.
+func (c *Client) userCommands() *protocol.Registry {
+	r := protocol.NewRegistry()
+	r.Malformed = func(msg *protocol.Message, err error) {
+		log.WithFields(log.Fields{
+			"event":   "trackUsers",
+			"command": msg.Command,
+			"data":    string(msg.Data),
+			"error":   err,
+		}).Warn("could not update user registry")
+	}
+
+	protocol.Handle(r, "ADDUSER", func(user *protocol.User) {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		// a CLIENTSTATUS can beat the ADDUSER; keep its status
+		if existing, ok := c.users[user.Name]; ok {
+			user.Status = existing.Status
+		}
+		c.users[user.Name] = user
+	})
+
+	r.HandleText("REMOVEUSER", func(data string) {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		delete(c.users, strings.TrimSpace(data))
+	})
+
+	protocol.Handle(r, "CLIENTSTATUS", func(status *protocol.ClientStatus) {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		user, ok := c.users[status.Name]
+		if !ok {
+			user = &protocol.User{Name: status.Name}
+			c.users[status.Name] = user
+		}
+		user.Status = status.Status
+	})
+
+	return r
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"github.com/kanatohodets/go-match/spring/lobby/protocol"
+	"testing"
+)
+
+func TestTrackUsers(t *testing.T) {
+	c := New(Config{})
+	c.users = make(map[string]*protocol.User)
+
+	lines := []string{
+		// a status can arrive before the user it belongs to
+		"CLIENTSTATUS alice 1",
+		"ADDUSER alice DE 1001 SpringLobby 0.270",
+		"ADDUSER bob US 1002",
+		"CLIENTSTATUS bob 64",
+		// malformed: bob keeps his status
+		"CLIENTSTATUS bob lots",
+		"ADDUSER carol",
+		"ADDUSER dave NL 1004",
+		"REMOVEUSER dave",
+		// not about users at all
+		"QUEUELEFT {}",
+	}
+
+	for _, line := range lines {
+		msg, err := protocol.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.tracking.Dispatch(msg)
+	}
+
+	want := map[string]protocol.User{
+		"alice": {Name: "alice", Country: "DE", AccountID: "1001", LobbyID: "SpringLobby 0.270", Status: 1},
+		"bob":   {Name: "bob", Country: "US", AccountID: "1002", Status: 64},
+	}
+
+	if len(c.Users()) != len(want) {
+		t.Errorf("users are %+v, want %+v", c.Users(), want)
+	}
+	for name, user := range want {
+		got, ok := c.User(name)
+		if !ok || got != user {
+			t.Errorf("%v is %+v, want %+v", name, got, user)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package protocol
 
+import "strings"
+
 type JoinQueueRequest struct {
-	UserNames []string `json:"userNames"`
-	Name      string   `json:"name"`
+	UserNames      []string `json:"userNames"`
+	Name           string   `json:"name"`
+	MapPreferences []string `json:"mapPreferences,omitempty"`
+	MapBans        []string `json:"mapBans,omitempty"`
 }
 
 type JoinQueueAccept struct {
@@ -53,5 +57,26 @@ type QueueLeft struct {
 }
 
 type CloseQueue struct {
-	Name string `json:"name"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Failed is the server refusing a command. it is not JSON: the data is
+// tab-separated key=value pairs, e.g. "msg=queue already exists\tcmd=OPENQUEUE".
+type Failed struct {
+	Command string
+	Message string
+}
+
+func ParseFailed(data []byte) Failed {
+	var failed Failed
+	for _, pair := range strings.Split(string(data), "\t") {
+		switch {
+		case strings.HasPrefix(pair, "cmd="):
+			failed.Command = strings.TrimPrefix(pair, "cmd=")
+		case strings.HasPrefix(pair, "msg="):
+			failed.Message = strings.TrimPrefix(pair, "msg=")
+		}
+	}
+	return failed
 }
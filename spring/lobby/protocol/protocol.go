@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 )
 
@@ -21,52 +22,93 @@ func (m *Message) Bytes() []byte {
 // having the message data by a []byte causes some casting headaches here, but
 // it makes life easy on the consuming side (since the payload can be given
 // directly to json.Unmarshal)
+//
+// words (params without spaces) are joined by spaces. the first sentence (a
+// param with spaces) and everything after it are joined by tabs, so Params
+// can tell where each one ends.
 func Prepare(command string, params []string) *Message {
-	pieces := [][]byte{}
+	var data bytes.Buffer
+	sentences := false
 	for i, param := range params {
-		// replace tabs with 2 spaces (tabs are a protocol-reserved character)
-		if strings.Contains(param, "\t") {
-			param = strings.Replace(param, "\t", "  ", -1)
+		// tabs and line breaks are protocol-reserved: tabs become 2 spaces,
+		// and a line break would end the command early
+		param = strings.Replace(param, "\t", "  ", -1)
+		param = strings.NewReplacer("\r", " ", "\n", " ").Replace(param)
+
+		if strings.Contains(param, " ") {
+			sentences = true
 		}
 
-		sentence := strings.Contains(param, " ")
-		if sentence {
-			// force the previous seperator into tab
-			if i > 0 {
-				pieces[len(pieces)-1] = []byte("\t")
+		if i > 0 {
+			if sentences {
+				data.WriteString("\t")
+			} else {
+				data.WriteString(" ")
 			}
-
-			pieces = append(pieces, []byte(param))
-			pieces = append(pieces, []byte("\t"))
-		} else {
-			pieces = append(pieces, []byte(param))
-			pieces = append(pieces, []byte(" "))
 		}
-	}
-
-	// chop off trailing whitespace
-	if len(pieces) > 1 {
-		pieces = pieces[:len(pieces)-1]
+		data.WriteString(param)
 	}
 
 	return &Message{
 		Command: command,
-		Data:    bytes.Join(pieces, []byte("")),
+		Data:    data.Bytes(),
 	}
 }
 
-func Parse(line string) *Message {
-	mark := strings.Index(line, " ")
+// Parse splits a line from the server into its command and data. a line with
+// no command is an error. the data is kept as sent, down to trailing
+// whitespace: it may end in empty params.
+func Parse(line string) (*Message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	line = strings.TrimLeft(line, " \t")
+	if line == "" {
+		return nil, fmt.Errorf("protocol.Parse: empty line")
+	}
+
+	mark := strings.IndexAny(line, " \t")
 	// no space, no command params, so message is a single command like 'PONG'
 	if mark == -1 {
 		return &Message{
-			Command: strings.TrimSpace(line),
+			Command: line,
 			Data:    []byte(""),
-		}
+		}, nil
 	}
 
 	return &Message{
 		Command: line[:mark],
-		Data:    []byte(strings.TrimSpace(line[mark:])),
+		Data:    []byte(line[mark+1:]),
+	}, nil
+}
+
+// Params splits a message's data back into the params Prepare built it from.
+// the lobby protocol puts a command's words (no spaces allowed) first and its
+// sentences after, so the first 'words' params are split on any whitespace
+// and the rest on tabs only. it is an error for data to run out before all
+// the words are read; empty data still holds one empty word.
+func (m *Message) Params(words int) ([]string, error) {
+	data := string(m.Data)
+	params := []string{}
+	ended := false
+	for i := 0; i < words; i++ {
+		if ended {
+			return nil, fmt.Errorf("protocol.Params: %v has %d words, expected at least %d", m.Command, i, words)
+		}
+
+		mark := strings.IndexAny(data, " \t")
+		if mark == -1 {
+			params = append(params, data)
+			ended = true
+			continue
+		}
+
+		params = append(params, data[:mark])
+		data = data[mark+1:]
+	}
+
+	// with no words, empty data is no params at all
+	if !ended && (words > 0 || data != "") {
+		params = append(params, strings.Split(data, "\t")...)
 	}
+
+	return params, nil
 }
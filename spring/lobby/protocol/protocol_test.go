@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sanitize is what Prepare does to a param before sending it
+func sanitize(param string) string {
+	param = strings.Replace(param, "\t", "  ", -1)
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(param)
+}
+
+// roundTrip sends params through Prepare, Bytes and Parse, and reads them
+// back with as many words as lead the params.
+func roundTrip(t *testing.T, params []string) []string {
+	line := string(Prepare("CMD", params).Bytes())
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("%q became %q, which isn't a single line", params, line)
+	}
+
+	msg, err := Parse(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		t.Fatalf("%q became %q, which doesn't parse: %v", params, line, err)
+	}
+	if msg.Command != "CMD" {
+		t.Fatalf("%q became %q, which parses as command %q", params, line, msg.Command)
+	}
+
+	words := 0
+	for _, param := range params {
+		if strings.Contains(sanitize(param), " ") {
+			break
+		}
+		words++
+	}
+
+	got, err := msg.Params(words)
+	if err != nil {
+		t.Fatalf("%q became %q, which has too few words: %v", params, line, err)
+	}
+	return got
+}
+
+func TestPrepareParams(t *testing.T) {
+	cases := []struct {
+		params []string
+		data   string
+	}{
+		{[]string{}, ""},
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b"}, "a b"},
+		{[]string{"a b", "c", "d"}, "a b\tc\td"},
+		{[]string{"a b", "c d"}, "a b\tc d"},
+		{[]string{"x", "y", "a b", "c"}, "x y\ta b\tc"},
+		{[]string{"x", ""}, "x "},
+		{[]string{"a b", ""}, "a b\t"},
+		{[]string{"tab\there"}, "tab  here"},
+		{[]string{"x", "line\nbreak"}, "x\tline break"},
+	}
+
+	for _, c := range cases {
+		data := string(Prepare("CMD", c.params).Data)
+		if data != c.data {
+			t.Errorf("Prepare(%q) data is %q, want %q", c.params, data, c.data)
+		}
+
+		want := []string{}
+		for _, param := range c.params {
+			want = append(want, sanitize(param))
+		}
+		got := roundTrip(t, c.params)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q came back as %q, want %q", c.params, got, want)
+		}
+	}
+}
+
+func TestParamsTooFewWords(t *testing.T) {
+	msg := &Message{Command: "CLIENTSTATUS", Data: []byte("bob")}
+	_, err := msg.Params(2)
+	if err == nil {
+		t.Errorf("Params(2) of %q didn't fail", msg.Data)
+	}
+}
+
+func FuzzPrepareParse(f *testing.F) {
+	f.Add("a b", "c", "d", uint8(3))
+	f.Add("x", "", "a b", uint8(3))
+	f.Add("", "", "", uint8(2))
+	f.Add("word", "sentence\twith tab", "", uint8(3))
+	f.Add("a\r\nb", " ", "\t", uint8(3))
+
+	f.Fuzz(func(t *testing.T, a, b, c string, n uint8) {
+		params := []string{a, b, c}[:n%4]
+
+		want := []string{}
+		for _, param := range params {
+			want = append(want, sanitize(param))
+		}
+
+		got := roundTrip(t, params)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q came back as %q, want %q", params, got, want)
+		}
+	})
+}
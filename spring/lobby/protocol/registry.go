@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+type registration struct {
+	// dispatch decodes the data and hands it to the handler; data which
+	// doesn't decode comes back as an error, and the handler isn't called
+	dispatch func(msg *Message) error
+	// malformed is the command's own handling for data which doesn't decode,
+	// on top of Registry.Malformed. may be nil
+	malformed func(msg *Message, err error)
+}
+
+// Registry maps lobby commands to the Go types their data decodes into, and
+// to the handler for each. commands with no registration, and data that
+// doesn't decode, each go down one shared path.
+type Registry struct {
+	commands map[string]registration
+
+	// Unknown is called with messages whose command is not registered
+	Unknown func(msg *Message)
+	// Malformed is called with messages whose data doesn't decode
+	Malformed func(msg *Message, err error)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		commands:  make(map[string]registration),
+		Unknown:   func(*Message) {},
+		Malformed: func(*Message, error) {},
+	}
+}
+
+// Handle sets up a command whose JSON data decodes into a T, e.g.
+// Handle(r, "QUEUELEFT", func(msg *QueueLeft) {...}). every message gets a
+// fresh T.
+func Handle[T any](r *Registry, command string, handler func(payload *T)) {
+	HandlePartial(r, command, handler, nil)
+}
+
+// HandlePartial is Handle, plus malformed for data which doesn't decode. it
+// gets whatever did decode before the error (often enough to reply to the
+// sender) and is called after Registry.Malformed.
+func HandlePartial[T any](r *Registry, command string, handler func(payload *T), malformed func(payload *T, err error)) {
+	reg := registration{
+		dispatch: func(msg *Message) error {
+			payload, err := decode[T](msg)
+			if err != nil {
+				return err
+			}
+			handler(payload)
+			return nil
+		},
+	}
+
+	if malformed != nil {
+		reg.malformed = func(msg *Message, err error) {
+			// decoding again is cheap, and only happens for broken messages
+			payload, _ := decode[T](msg)
+			malformed(payload, err)
+		}
+	}
+
+	r.commands[command] = reg
+}
+
+// HandleText sets up a plain text command: its data reaches the handler
+// as-is.
+func (r *Registry) HandleText(command string, handler func(data string)) {
+	r.commands[command] = registration{
+		dispatch: func(msg *Message) error {
+			handler(string(msg.Data))
+			return nil
+		},
+	}
+}
+
+// Ignore registers commands which are known, but of no interest.
+func (r *Registry) Ignore(commands ...string) {
+	for _, command := range commands {
+		r.HandleText(command, func(string) {})
+	}
+}
+
+// Dispatch decodes a message and hands it to the registered handler.
+func (r *Registry) Dispatch(msg *Message) {
+	reg, ok := r.commands[msg.Command]
+	if !ok {
+		r.Unknown(msg)
+		return
+	}
+
+	err := reg.dispatch(msg)
+	if err != nil {
+		r.Malformed(msg, err)
+		if reg.malformed != nil {
+			reg.malformed(msg, err)
+		}
+	}
+}
+
+// MessageUnmarshaler is implemented by payloads which aren't JSON, like the
+// words and sentences of the older lobby commands. Handle uses it instead of
+// encoding/json when *T has it.
+type MessageUnmarshaler interface {
+	UnmarshalMessage(msg *Message) error
+}
+
+// decode always returns a payload: on error, it holds whatever decoded.
+func decode[T any](msg *Message) (*T, error) {
+	payload := new(T)
+
+	var err error
+	if unmarshaler, ok := any(payload).(MessageUnmarshaler); ok {
+		err = unmarshaler.UnmarshalMessage(msg)
+	} else {
+		err = json.Unmarshal(msg.Data, payload)
+	}
+	if err != nil {
+		return payload, fmt.Errorf("protocol.Registry: could not decode %v data as %v: %v", msg.Command, reflect.TypeOf(payload).Elem(), err)
+	}
+	return payload, nil
+}
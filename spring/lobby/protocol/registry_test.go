@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkDecoder registers T for a command and dispatches data at it: exactly
+// one of the handler or the malformed paths has to run, and whatever the
+// handler gets has to survive another trip through JSON.
+func checkDecoder[T any](t *testing.T, command string, data []byte) {
+	var handled *T
+	var malformed, partial, unknown int
+
+	r := NewRegistry()
+	HandlePartial(r, command, func(payload *T) {
+		handled = payload
+	}, func(payload *T, err error) {
+		if payload == nil {
+			t.Errorf("%s: malformed handler got a nil payload", command)
+		}
+		partial++
+	})
+	r.Malformed = func(*Message, error) { malformed++ }
+	r.Unknown = func(*Message) { unknown++ }
+
+	r.Dispatch(&Message{Command: command, Data: data})
+
+	if unknown != 0 {
+		t.Fatalf("%s: registered command went to Unknown", command)
+	}
+	if handled == nil {
+		if malformed != 1 || partial != 1 {
+			t.Fatalf("%s: %q wasn't handled, but Malformed ran %d times and the command's own malformed %d", command, data, malformed, partial)
+		}
+		return
+	}
+	if malformed != 0 || partial != 0 {
+		t.Fatalf("%s: %q was both handled and malformed", command, data)
+	}
+
+	encoded, err := json.Marshal(handled)
+	if err != nil {
+		t.Fatalf("%s: could not re-encode %+v: %v", command, handled, err)
+	}
+	again := new(T)
+	err = json.Unmarshal(encoded, again)
+	if err != nil {
+		t.Fatalf("%s: could not decode re-encoded %s: %v", command, encoded, err)
+	}
+	if !reflect.DeepEqual(normalize(handled), normalize(again)) {
+		t.Fatalf("%s: %+v changed to %+v going through JSON again", command, handled, again)
+	}
+}
+
+// normalize makes nil and empty slices compare equal: omitempty turns one
+// into the other.
+func normalize(v interface{}) string {
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
+
+func FuzzDecoders(f *testing.F) {
+	f.Add([]byte(`{"userNames":["bob","alice"],"name":"1v1","mapPreferences":["DeltaSiegeDry"],"mapBans":["Tabula"]}`))
+	f.Add([]byte(`{"userName":"bob","name":"1v1","response":"ready","responseTime":3}`))
+	f.Add([]byte(`{"userNames":["bob"],"name":"1v1"}`))
+	f.Add([]byte(`{"userNames":"bob","name":1}`))
+	f.Add([]byte(`{"userNames":[null],"name":null}`))
+	f.Add([]byte(`[]`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(``))
+	f.Add([]byte(`{"name":"\u0000\ud800"}`))
+	f.Add([]byte("bob\tDE 1234 SpringLobby 0.270"))
+	f.Add([]byte("bob 3"))
+	f.Add([]byte("bob x"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkDecoder[JoinQueueRequest](t, "JOINQUEUEREQUEST", data)
+		checkDecoder[QueueLeft](t, "QUEUELEFT", data)
+		checkDecoder[ReadyCheckResponse](t, "READYCHECKRESPONSE", data)
+		checkDecoder[OpenQueue](t, "QUEUEOPENED", data)
+		checkDecoder[CloseQueue](t, "CLOSEQUEUE", data)
+		checkDecoder[User](t, "ADDUSER", data)
+		checkDecoder[ClientStatus](t, "CLIENTSTATUS", data)
+	})
+}
+
+func FuzzParseFailed(f *testing.F) {
+	f.Add([]byte("msg=queue already exists\tcmd=OPENQUEUE"))
+	f.Add([]byte("cmd=OPENQUEUE"))
+	f.Add([]byte("msg=a=b\t\tcmd="))
+	f.Add([]byte(""))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		failed := ParseFailed(data)
+		if strings.Contains(failed.Command, "\t") || strings.Contains(failed.Message, "\t") {
+			t.Fatalf("%q parsed to %+v, which spans pairs", data, failed)
+		}
+		if !strings.Contains(string(data), failed.Command) || !strings.Contains(string(data), failed.Message) {
+			t.Fatalf("%q parsed to %+v, which isn't in the data", data, failed)
+		}
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	var left *QueueLeft
+	var text string
+	var unknown []string
+
+	r := NewRegistry()
+	Handle(r, "QUEUELEFT", func(msg *QueueLeft) { left = msg })
+	r.HandleText("REMOVEUSER", func(data string) { text = data })
+	r.Ignore("PONG")
+	r.Unknown = func(msg *Message) { unknown = append(unknown, msg.Command) }
+
+	r.Dispatch(&Message{Command: "QUEUELEFT", Data: []byte(`{"userNames":["bob"],"name":"1v1"}`)})
+	r.Dispatch(&Message{Command: "REMOVEUSER", Data: []byte("bob")})
+	r.Dispatch(&Message{Command: "PONG"})
+	r.Dispatch(&Message{Command: "WHAT"})
+
+	if left == nil || left.Name != "1v1" || !reflect.DeepEqual(left.UserNames, []string{"bob"}) {
+		t.Errorf("QUEUELEFT handler got %+v", left)
+	}
+	if text != "bob" {
+		t.Errorf("REMOVEUSER handler got %q", text)
+	}
+	if !reflect.DeepEqual(unknown, []string{"WHAT"}) {
+		t.Errorf("unknown commands were %v, want [WHAT]", unknown)
+	}
+}
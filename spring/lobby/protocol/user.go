@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UserStatus is the bitfield sent in CLIENTSTATUS.
+type UserStatus int
+
+func (s UserStatus) InGame() bool    { return s&1 != 0 }
+func (s UserStatus) Away() bool      { return s&2 != 0 }
+func (s UserStatus) Rank() int       { return int(s>>2) & 7 }
+func (s UserStatus) Moderator() bool { return s&32 != 0 }
+func (s UserStatus) Bot() bool       { return s&64 != 0 }
+
+// User is someone online in the lobby, as announced by ADDUSER and updated by
+// CLIENTSTATUS.
+type User struct {
+	Name      string
+	Country   string
+	AccountID string
+	LobbyID   string
+	Status    UserStatus
+}
+
+// UnmarshalMessage reads 'ADDUSER userName country accountID lobbyID'.
+// lobbyID is a sentence, and may be missing.
+func (u *User) UnmarshalMessage(msg *Message) error {
+	params, err := msg.Params(3)
+	if err != nil {
+		return err
+	}
+
+	*u = User{
+		Name:      params[0],
+		Country:   params[1],
+		AccountID: params[2],
+		LobbyID:   strings.Join(params[3:], " "),
+	}
+	return nil
+}
+
+// ClientStatus is 'CLIENTSTATUS userName status'.
+type ClientStatus struct {
+	Name   string
+	Status UserStatus
+}
+
+func (s *ClientStatus) UnmarshalMessage(msg *Message) error {
+	params, err := msg.Params(2)
+	if err != nil {
+		return err
+	}
+
+	status, err := strconv.Atoi(params[1])
+	if err != nil {
+		return fmt.Errorf("bad status %q: %v", params[1], err)
+	}
+
+	*s = ClientStatus{Name: params[0], Status: UserStatus(status)}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot"
+	"net/http"
+	"os"
+	"time"
+)
+
+// status prints a running matchbot's status, from its admin API.
+func status(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	admin := flags.String("admin", defaultAdmin, "the matchbot's admin API address")
+	asJSON := flags.Bool("json", false, "print the raw JSON")
+	flags.Parse(args)
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	res, err := httpClient.Get(fmt.Sprintf("http://%v/status", *admin))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not reach the matchbot: %v\n", err)
+		return 1
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "matchbot answered %v\n", res.Status)
+		return 1
+	}
+
+	var s matchbot.Status
+	err = json.NewDecoder(res.Body).Decode(&s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not decode the matchbot's status: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(s)
+		return 0
+	}
+
+	fmt.Printf("connected:    %v (%v)\n", s.Connected, s.LoginState)
+	fmt.Printf("sessions:     %v, %v attempts since the last login\n", s.Connection.Sessions, s.Connection.Attempts)
+	if s.Connection.LastError != "" {
+		fmt.Printf("last error:   %v\n", s.Connection.LastError)
+	}
+	if !s.Connection.NextAttempt.IsZero() {
+		fmt.Printf("next attempt: %v\n", s.Connection.NextAttempt.Format(time.RFC3339))
+	}
+	fmt.Printf("events:       %v pending of %v, high water %v\n", s.Events.Pending, s.Events.Capacity, s.Events.HighWater)
+	fmt.Printf("ready checks: %v\n", s.ReadyChecks)
+	fmt.Printf("queues:       %v\n", len(s.Queues))
+	for _, q := range s.Queues {
+		fmt.Printf("  %-20s %v players\n", q.Name, q.Players)
+	}
+	return 0
+}
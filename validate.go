@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kanatohodets/go-match/matchbot/queue"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// validate lints queues files, and the Lua scripts their queues run, printing
+// each problem as file:line: problem. .lua files given directly are checked
+// on their own. exits 0 if everything is valid.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go-match validate queues.json|script.lua...")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	checked := map[string]bool{}
+	checkScript := func(file string) {
+		if checked[file] {
+			return
+		}
+		checked[file] = true
+
+		err := queue.CheckScript(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+			status = 1
+		}
+	}
+
+	for _, file := range flags.Args() {
+		if strings.HasSuffix(file, ".lua") {
+			checkScript(file)
+			continue
+		}
+
+		defs, err := readQueues(file)
+		if err != nil {
+			printQueuesError(file, err)
+			status = 1
+			continue
+		}
+
+		for _, def := range defs {
+			checkScript(def.ScriptFile())
+		}
+	}
+	return status
+}
+
+func readQueues(file string) ([]*queue.Definition, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return queue.ParseDefinitions(b)
+}
+
+// findQueue picks a queue out of a queues file: the one named, or the first.
+func findQueue(file string, name string) (*queue.Definition, error) {
+	defs, err := readQueues(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range defs {
+		if name == "" || def.Name == name {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("no queue %q in %v", name, file)
+}
+
+func printQueuesError(file string, err error) {
+	errs, ok := err.(queue.ValidationErrors)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+		return
+	}
+
+	for _, e := range errs {
+		if e.Queue == "" {
+			fmt.Fprintf(os.Stderr, "%v:%v: %v\n", file, e.Line, e.Problem)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v:%v: queue %q: %v\n", file, e.Line, e.Queue, e.Problem)
+		}
+	}
+}